@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log"
+	"net/http"
+	"net/url"
+	"s3gw/ranger"
+	"strings"
+	"time"
+)
+
+// Explanation is the decision of the policies on a request of the explain endpoint
+type Explanation struct {
+	User       string
+	Groups     []string
+	Roles      []string
+	Owner      string
+	Location   string
+	AccessType string
+	Method     string
+	Headers    http.Header
+	ClientIp   string
+	Tags       []ranger.Tag
+	Result     *ranger.AccessResult
+}
+
+type PolicyStatus struct {
+	ServiceName      string
+	PolicyVersion    int
+	PolicyUpdateTime int64
+	Policies         int
+	LastRefresh      time.Time
+	StaleSeconds     float64
+	Failures         int64
+	Problems         int   // validation problems of the last policies
+	Rejected         int64 // policy versions refused in strict mode
+}
+
+func init() {
+	expvar.Publish("policy", expvar.Func(func() interface{} { return getPolicyStatus() }))
+}
+
+func getPolicyStatus() *PolicyStatus {
+	status := &PolicyStatus{}
+	if policies == nil {
+		return status
+	}
+
+	if service := policies.Service(); service != nil {
+		status.ServiceName = service.ServiceName
+		status.PolicyVersion = service.PolicyVersion
+		status.PolicyUpdateTime = service.PolicyUpdateTime
+		status.Policies = len(service.Policies)
+	}
+	status.LastRefresh = policies.LastRefresh()
+	status.StaleSeconds = policies.Staleness().Seconds()
+	status.Failures = policies.Failures()
+	status.Problems = len(policies.Problems())
+	status.Rejected = policies.Rejected()
+
+	return status
+}
+
+// NewAdminHandler returns the handler of the admin endpoints, which must not be
+// exposed on the proxy port. They reveal who can access what, so if a token is given
+// every request must carry it as bearer token
+func NewAdminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/status", handleStatus)
+	mux.HandleFunc("/explain", handleExplain)
+	mux.HandleFunc("/validation", handleValidation)
+	mux.HandleFunc("/changes", handleChanges)
+
+	if token == "" {
+		return mux
+	}
+
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Cannot write admin response due to error %s\n", err)
+	}
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, getPolicyStatus())
+}
+
+// handleValidation returns the problems found by validating the last policies against
+// the service definition
+func handleValidation(w http.ResponseWriter, r *http.Request) {
+	problems := []ranger.PolicyProblem{}
+	if policies != nil {
+		problems = append(problems, policies.Problems()...)
+	}
+
+	writeJSON(w, problems)
+}
+
+// handleChanges returns the policy changes of the last refreshes, the latest first
+func handleChanges(w http.ResponseWriter, r *http.Request) {
+	changes := []*ranger.PolicyDiff{}
+	if policies != nil {
+		changes = append(changes, policies.Changes()...)
+	}
+
+	writeJSON(w, changes)
+}
+
+// handleExplain evaluates the request given by the parameters user, groups (comma
+// separated, resolved if omitted), location (/bucket/key), accessType, ip and optionally
+// owner, method, versionId, userAgent, tagging and header (Name: value, repeatable), and
+// returns the decision with its explanation
+func handleExplain(w http.ResponseWriter, r *http.Request) {
+	service := policies.Service()
+	if service == nil {
+		http.Error(w, "No policies loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	e := &Explanation{
+		User:       r.FormValue("user"),
+		Location:   r.FormValue("location"),
+		AccessType: r.FormValue("accessType"),
+		ClientIp:   r.FormValue("ip"),
+		Owner:      r.FormValue("owner"),
+	}
+	if e.User == "" || e.Location == "" || e.AccessType == "" {
+		http.Error(w, "user, location and accessType are required", http.StatusBadRequest)
+		return
+	}
+	if groups := r.FormValue("groups"); groups != "" {
+		e.Groups = strings.Split(groups, ",")
+	} else if groupProvider != nil {
+		groups, err := groupProvider.Groups(e.User)
+		if err != nil {
+			http.Error(w, "Cannot resolve groups: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		e.Groups = groups
+	}
+
+	explained, err := newExplainedRequest(r, e)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e.Method = explained.Method
+	e.Headers = explained.Header
+
+	bucket, key := GetBucketObjectKey(explained.URL.Path)
+
+	// the same request and context as the proxy evaluates, the enrichers see the
+	// explained request and not the request to the admin endpoint
+	req := newAccessRequest(r.Context(), e.User, e.Groups, bucket, key, e.Owner)
+	req.AccessType = e.AccessType
+	req.ClientIpAddress = e.ClientIp
+	req.RemoteIpAddress = e.ClientIp
+	req.Headers = explained.Header
+	if err := enrich(req, explained); err != nil {
+		if err == errInvalidRequest {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Cannot load the context of the request: "+err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+
+	e.Location = req.Resource.Location
+	e.Owner = req.Resource.Owner
+	e.Roles = req.UserRoles
+	e.Tags = req.Tags
+	e.Result = service.Evaluate(req)
+
+	writeJSON(w, e)
+}
+
+// newExplainedRequest builds the S3 request of the explain parameters, the method is
+// derived from the access type unless it is given
+func newExplainedRequest(r *http.Request, e *Explanation) (*http.Request, error) {
+	query := url.Values{}
+	if versionId := r.FormValue("versionId"); versionId != "" {
+		query.Set("versionId", versionId)
+	}
+
+	method := strings.ToUpper(r.FormValue("method"))
+	switch e.AccessType {
+	case ranger.Write:
+		if method == "" {
+			method = http.MethodPut
+		}
+	case ranger.WriteAcp:
+		method = http.MethodPut
+		query.Set("acl", "")
+	case ranger.ReadAcp:
+		method = http.MethodGet
+		query.Set("acl", "")
+	default:
+		if method == "" {
+			method = http.MethodGet
+		}
+	}
+
+	target := &url.URL{Path: "/" + strings.TrimPrefix(e.Location, "/"), RawQuery: query.Encode()}
+	explained, err := http.NewRequestWithContext(r.Context(), method, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, header := range r.Form["header"] {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, errors.New("Invalid header " + header + ", expected Name: value")
+		}
+		explained.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if userAgent := r.FormValue("userAgent"); userAgent != "" {
+		explained.Header.Set("User-Agent", userAgent)
+	}
+	if tagging := r.FormValue("tagging"); tagging != "" {
+		explained.Header.Set("x-amz-tagging", tagging)
+	}
+
+	return explained, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestExplainedRequest(t *testing.T) {
+	cases := []struct {
+		name       string
+		params     url.Values
+		accessType string
+		method     string
+		uri        string
+		headers    map[string]string
+		err        bool
+	}{
+		{"read", url.Values{}, "read", "GET", "/bucket/key", nil, false},
+		{"write", url.Values{}, "write", "PUT", "/bucket/key", nil, false},
+		{"delete", url.Values{"method": {"delete"}}, "write", "DELETE", "/bucket/key", nil, false},
+		{"acl", url.Values{"method": {"POST"}}, "write_acp", "PUT", "/bucket/key?acl=", nil, false},
+		{"version", url.Values{"versionId": {"v1"}}, "read_acp", "GET", "/bucket/key?acl=&versionId=v1", nil, false},
+		{"headers", url.Values{"userAgent": {"aws-cli/2.0.0"}, "tagging": {"a=b"}, "header": {"X-Amz-Meta-Owner: bob", "Tenant:t1"}},
+			"write", "PUT", "/bucket/key", map[string]string{"User-Agent": "aws-cli/2.0.0", "X-Amz-Tagging": "a=b", "X-Amz-Meta-Owner": "bob", "Tenant": "t1"}, false},
+		{"invalid header", url.Values{"header": {"bogus"}}, "read", "", "", nil, true},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/explain?"+c.params.Encode(), nil)
+		r.Header.Set("User-Agent", "curl/8.0")
+		r.ParseForm()
+
+		explained, err := newExplainedRequest(r, &Explanation{Location: "bucket/key", AccessType: c.accessType})
+		if (err != nil) != c.err {
+			t.Errorf("%s: error %v", c.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if explained.Method != c.method || explained.URL.RequestURI() != c.uri {
+			t.Errorf("%s: %s %s, want %s %s", c.name, explained.Method, explained.URL.RequestURI(), c.method, c.uri)
+		}
+		if c.headers == nil && len(explained.Header) > 0 {
+			t.Errorf("%s: headers %v of the admin request", c.name, explained.Header)
+		}
+		for name, value := range c.headers {
+			if got := explained.Header.Get(name); got != value {
+				t.Errorf("%s: header %s = %q, want %q", c.name, name, got, value)
+			}
+		}
+	}
+}
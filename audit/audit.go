@@ -0,0 +1,201 @@
+package audit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"expvar"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ResultDenied  = 0
+	ResultAllowed = 1
+
+	EnforcerRanger = "ranger-acl"
+	LogTypeAccess  = "RangerAudit"
+
+	defaultQueueSize     = 10000
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+)
+
+// Event is an authorization decision in the format of the Ranger audit (AuthzAuditEvent),
+// the json names are the fields of the Ranger audit store
+type Event struct {
+	Id            string    `json:"id"`
+	RepoType      int       `json:"repoType"`
+	Repo          string    `json:"repo"`
+	User          string    `json:"reqUser"`
+	EventTime     time.Time `json:"evtTime"`
+	AccessType    string    `json:"access"`
+	Resource      string    `json:"resource"`
+	ResourceType  string    `json:"resType"`
+	Action        string    `json:"action"`
+	Result        int       `json:"result"`
+	Agent         string    `json:"agent"`
+	PolicyId      int       `json:"policy"`
+	PolicyVersion int       `json:"policyVersion,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+	Enforcer      string    `json:"enforcer"`
+	SessionId     string    `json:"sess,omitempty"`
+	ClientType    string    `json:"cliType,omitempty"`
+	ClientIp      string    `json:"cliIP"`
+	RequestData   string    `json:"reqData,omitempty"`
+	AgentHost     string    `json:"agentHost"`
+	LogType       string    `json:"logType"`
+	EventCount    int64     `json:"event_count"`
+	EventDuration int64     `json:"event_dur_ms"`
+	Tags          []string  `json:"tags,omitempty"`
+	ClusterName   string    `json:"cluster,omitempty"`
+
+	// outcome of the request, not part of the Ranger audit schema
+	Status         int    `json:"status,omitempty"`
+	UpstreamStatus int    `json:"upstreamStatus,omitempty"`
+	BytesIn        int64  `json:"bytesIn"`
+	BytesOut       int64  `json:"bytesOut"`
+	RequestId      string `json:"reqId,omitempty"`
+}
+
+// Sink is a destination of audit events
+type Sink interface {
+	Write(events []*Event) error
+	Close() error
+}
+
+var (
+	metrics = expvar.NewMap("audit")
+
+	hostname string
+)
+
+func init() {
+	var err error
+	if hostname, err = os.Hostname(); err != nil {
+		hostname = "localhost"
+	}
+}
+
+// Auditor sends audit events asynchronously to its sinks. Events are dropped when
+// the queue is full so that auditing never blocks requests
+type Auditor struct {
+	sinks         []Sink
+	queue         chan *Event
+	batchSize     int
+	flushInterval time.Duration
+	wg            sync.WaitGroup
+}
+
+func NewAuditor(queueSize int, batchSize int, sinks ...Sink) *Auditor {
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	a := &Auditor{
+		sinks:         sinks,
+		queue:         make(chan *Event, queueSize),
+		batchSize:     batchSize,
+		flushInterval: defaultFlushInterval,
+	}
+
+	a.wg.Add(1)
+	go a.run()
+
+	return a
+}
+
+// Log queues an event, it returns false if the event was dropped
+func (a *Auditor) Log(e *Event) bool {
+	if e.Id == "" {
+		e.Id = newId()
+	}
+	if e.AgentHost == "" {
+		e.AgentHost = hostname
+	}
+	if e.Enforcer == "" {
+		e.Enforcer = EnforcerRanger
+	}
+	if e.LogType == "" {
+		e.LogType = LogTypeAccess
+	}
+	if e.EventCount == 0 {
+		e.EventCount = 1
+	}
+
+	select {
+	case a.queue <- e:
+		metrics.Add("queued", 1)
+		return true
+	default:
+		metrics.Add("dropped", 1)
+		return false
+	}
+}
+
+// Close flushes the queued events and closes the sinks
+func (a *Auditor) Close() {
+	close(a.queue)
+	a.wg.Wait()
+
+	for _, sink := range a.sinks {
+		if err := sink.Close(); err != nil {
+			log.Printf("Cannot close audit sink due to error %s\n", err)
+		}
+	}
+}
+
+// newId returns a random identifier for an event
+func newId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (a *Auditor) run() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Event, 0, a.batchSize)
+	for {
+		select {
+		case e, ok := <-a.queue:
+			if !ok {
+				a.flush(batch)
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) < a.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		a.flush(batch)
+		batch = make([]*Event, 0, a.batchSize)
+	}
+}
+
+func (a *Auditor) flush(batch []*Event) {
+	if len(batch) == 0 {
+		return
+	}
+
+	for _, sink := range a.sinks {
+		if err := sink.Write(batch); err != nil {
+			log.Printf("Cannot write %d audit events due to error %s\n", len(batch), err)
+			metrics.Add("errors", 1)
+			continue
+		}
+		metrics.Add("written", int64(len(batch)))
+	}
+}
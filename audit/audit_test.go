@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// recordingSink records the batches written to it and fails while failing is set
+type recordingSink struct {
+	mu      sync.Mutex
+	failing bool
+	batches [][]*Event
+	closed  bool
+}
+
+func (s *recordingSink) Write(events []*Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failing {
+		return errors.New("Sink unavailable")
+	}
+	s.batches = append(s.batches, events)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	return nil
+}
+
+func (s *recordingSink) setFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failing = failing
+}
+
+// users returns the users of the written events in the order they were written
+func (s *recordingSink) users() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var users []string
+	for _, batch := range s.batches {
+		for _, e := range batch {
+			users = append(users, e.User)
+		}
+	}
+	return users
+}
+
+func TestAuditorBatches(t *testing.T) {
+	cases := []struct {
+		batchSize int
+		events    int
+		batches   []int
+	}{
+		{2, 5, []int{2, 2, 1}},
+		{10, 3, []int{3}},
+		{3, 6, []int{3, 3}},
+		{1, 2, []int{1, 1}},
+		{5, 0, nil},
+	}
+
+	for _, c := range cases {
+		sink := &recordingSink{}
+		a := NewAuditor(100, c.batchSize, sink)
+		for i := 0; i < c.events; i++ {
+			if !a.Log(&Event{User: fmt.Sprint(i)}) {
+				t.Errorf("batch size %d: event %d dropped", c.batchSize, i)
+			}
+		}
+		a.Close()
+
+		var sizes []int
+		for _, batch := range sink.batches {
+			sizes = append(sizes, len(batch))
+		}
+		if fmt.Sprint(sizes) != fmt.Sprint(c.batches) {
+			t.Errorf("batch size %d with %d events: batches %v, want %v", c.batchSize, c.events, sizes, c.batches)
+		}
+		if !sink.closed {
+			t.Errorf("batch size %d: sink not closed", c.batchSize)
+		}
+	}
+}
+
+func TestAuditorDefaults(t *testing.T) {
+	sink := &recordingSink{}
+	a := NewAuditor(1, 1, sink)
+	a.Log(&Event{User: "bob", Enforcer: "custom"})
+	a.Close()
+
+	e := sink.batches[0][0]
+	if e.Id == "" || e.AgentHost == "" || e.Enforcer != "custom" || e.LogType != LogTypeAccess || e.EventCount != 1 {
+		t.Errorf("defaults of event %+v", e)
+	}
+}
@@ -0,0 +1,339 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSpoolMaxBytes   = 1 << 30
+	defaultSegmentBytes    = 4 << 20
+	defaultRetryInterval   = 10 * time.Second
+	defaultReplayBatchSize = 100
+	segmentSuffix          = ".json"
+)
+
+// Spool is a sink that writes events to its destination sink while it is available
+// and to segment files on local disk while it is not. Spooled events are replayed in
+// order once the destination recovers, new events are spooled until then so that
+// the order is kept. Spooled events survive a restart
+type Spool struct {
+	Name          string
+	RetryInterval time.Duration
+
+	sink         Sink
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+
+	mu          sync.Mutex
+	segments    []string // closed segments waiting for replay, oldest first
+	current     *os.File
+	currentSize int64
+	sequence    int64
+	size        int64
+	events      int64
+
+	depthBytes  *expvar.Int
+	depthEvents *expvar.Int
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewSpool creates a spool for the sink in dir and starts replaying events that were
+// spooled before. maxBytes limits the size of the spool, events that do not fit are dropped
+func NewSpool(name string, sink Sink, dir string, maxBytes int64) (*Spool, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultSpoolMaxBytes
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		Name:          name,
+		RetryInterval: defaultRetryInterval,
+		sink:          sink,
+		dir:           dir,
+		maxBytes:      maxBytes,
+		segmentBytes:  defaultSegmentBytes,
+		depthBytes:    new(expvar.Int),
+		depthEvents:   new(expvar.Int),
+		stop:          make(chan struct{}),
+	}
+	metrics.Set("spool_bytes_"+name, s.depthBytes)
+	metrics.Set("spool_events_"+name, s.depthEvents)
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.replay()
+
+	return s, nil
+}
+
+// load picks up the segments of a previous run
+func (s *Spool) load() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		if seq > s.sequence {
+			s.sequence = seq
+		}
+
+		path := filepath.Join(s.dir, name)
+		s.segments = append(s.segments, path)
+		s.size += file.Size()
+		s.events += countLines(path)
+	}
+	sort.Strings(s.segments)
+
+	if len(s.segments) > 0 {
+		log.Printf("Replaying %d spooled audit events for sink=%s\n", s.events, s.Name)
+	}
+	s.updateDepth()
+
+	return nil
+}
+
+func countLines(path string) int64 {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	var lines int64
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		lines++
+	}
+
+	return lines
+}
+
+func (s *Spool) updateDepth() {
+	s.depthBytes.Set(s.size)
+	s.depthEvents.Set(s.events)
+}
+
+// isSpooling returns true if events are waiting on disk
+func (s *Spool) isSpooling() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.segments) > 0 || s.current != nil
+}
+
+// Depth returns the number of events and bytes waiting on disk
+func (s *Spool) Depth() (int64, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.events, s.size
+}
+
+func (s *Spool) Write(events []*Event) error {
+	if !s.isSpooling() {
+		err := s.sink.Write(events)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Spooling audit events for sink=%s due to error %s\n", s.Name, err)
+	}
+
+	return s.append(events)
+}
+
+// append writes the events to the current segment
+func (s *Spool) append(events []*Event) error {
+	var lines []byte
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, data...), '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size+int64(len(lines)) > s.maxBytes {
+		metrics.Add("spool_dropped", int64(len(events)))
+		return fmt.Errorf("Audit spool for sink=%s is full, dropped %d events", s.Name, len(events))
+	}
+
+	if s.current == nil {
+		s.sequence++
+		path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", s.sequence, segmentSuffix))
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return err
+		}
+		s.current = file
+		s.currentSize = 0
+	}
+
+	if _, err := s.current.Write(lines); err != nil {
+		return err
+	}
+
+	s.currentSize += int64(len(lines))
+	s.size += int64(len(lines))
+	s.events += int64(len(events))
+	s.updateDepth()
+
+	if s.currentSize >= s.segmentBytes {
+		s.closeCurrent()
+	}
+
+	return nil
+}
+
+// closeCurrent queues the current segment for replay, must be called with the lock held
+func (s *Spool) closeCurrent() {
+	if s.current == nil {
+		return
+	}
+
+	if err := s.current.Close(); err != nil {
+		log.Printf("Cannot close audit spool segment=%s due to error %s\n", s.current.Name(), err)
+	}
+	s.segments = append(s.segments, s.current.Name())
+	s.current = nil
+}
+
+// next returns the oldest segment to replay, the current segment is closed if it is the
+// only one left
+func (s *Spool) next() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.segments) == 0 {
+		s.closeCurrent()
+	}
+	if len(s.segments) == 0 {
+		return ""
+	}
+
+	return s.segments[0]
+}
+
+func (s *Spool) replay() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(s.RetryInterval):
+		}
+
+		for segment := s.next(); segment != ""; segment = s.next() {
+			if err := s.replaySegment(segment); err != nil {
+				log.Printf("Cannot replay audit spool segment=%s for sink=%s due to error %s\n", segment, s.Name, err)
+				break
+			}
+		}
+	}
+}
+
+// replaySegment sends the events of a segment in batches and removes it once all of
+// them were written. A failed segment is retried as a whole, which may duplicate events
+func (s *Spool) replaySegment(segment string) error {
+	file, err := os.Open(segment)
+	if err != nil {
+		return err
+	}
+
+	var batch []*Event
+	var events int64
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		events++
+
+		var e Event
+		if err = json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Printf("Skipping invalid spooled audit event in segment=%s\n", segment)
+			continue
+		}
+		batch = append(batch, &e)
+
+		if len(batch) >= defaultReplayBatchSize {
+			if err = s.sink.Write(batch); err != nil {
+				file.Close()
+				return err
+			}
+			batch = nil
+		}
+	}
+	file.Close()
+
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		if err = s.sink.Write(batch); err != nil {
+			return err
+		}
+	}
+
+	info, err := os.Stat(segment)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(segment); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.segments = s.segments[1:]
+	s.size -= info.Size()
+	s.events -= events
+	s.updateDepth()
+	s.mu.Unlock()
+
+	metrics.Add("replayed", events)
+	return nil
+}
+
+// Close stops replaying and closes the destination sink, spooled events stay on disk
+func (s *Spool) Close() error {
+	close(s.stop)
+	s.wg.Wait()
+
+	s.mu.Lock()
+	s.closeCurrent()
+	s.mu.Unlock()
+
+	return s.sink.Close()
+}
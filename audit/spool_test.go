@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func events(from int, to int) []*Event {
+	var events []*Event
+	for i := from; i < to; i++ {
+		events = append(events, &Event{User: fmt.Sprint(i)})
+	}
+	return events
+}
+
+func TestSpoolReplaysInOrder(t *testing.T) {
+	cases := []struct {
+		name         string
+		segmentBytes int64
+		restart      bool // close and reopen the spool before the sink recovers
+	}{
+		{"one segment", defaultSegmentBytes, false},
+		{"many segments", 1, false},
+		{"restart", defaultSegmentBytes, true},
+		{"restart with many segments", 1, true},
+	}
+
+	for _, c := range cases {
+		dir := t.TempDir()
+		sink := &recordingSink{failing: true}
+		s, err := NewSpool("test", sink, dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.segmentBytes = c.segmentBytes
+
+		for i := 0; i < 5; i++ {
+			if err := s.Write(events(i*2, i*2+2)); err != nil {
+				t.Errorf("%s: write error %s", c.name, err)
+			}
+		}
+		if n, _ := s.Depth(); n != 10 {
+			t.Errorf("%s: depth %d, want 10", c.name, n)
+		}
+
+		if c.restart {
+			s.Close()
+			if s, err = NewSpool("test", sink, dir, 0); err != nil {
+				t.Fatal(err)
+			}
+			s.segmentBytes = c.segmentBytes
+			if n, _ := s.Depth(); n != 10 {
+				t.Errorf("%s: depth %d after restart, want 10", c.name, n)
+			}
+		}
+
+		// new events are spooled behind the old ones while spooling
+		sink.setFailing(false)
+		if err := s.Write(events(10, 12)); err != nil {
+			t.Errorf("%s: write error %s", c.name, err)
+		}
+
+		for segment := s.next(); segment != ""; segment = s.next() {
+			if err := s.replaySegment(segment); err != nil {
+				t.Fatalf("%s: replay error %s", c.name, err)
+			}
+		}
+		if n, bytes := s.Depth(); n != 0 || bytes != 0 {
+			t.Errorf("%s: %d events of %d bytes left in the spool", c.name, n, bytes)
+		}
+		s.Close()
+
+		if got, want := fmt.Sprint(sink.users()), fmt.Sprint(users(0, 12)); got != want {
+			t.Errorf("%s: replayed %s, want %s", c.name, got, want)
+		}
+	}
+}
+
+func users(from int, to int) []string {
+	var users []string
+	for _, e := range events(from, to) {
+		users = append(users, e.User)
+	}
+	return users
+}
+
+func TestSpoolMaxBytes(t *testing.T) {
+	// every event takes the same space as the users have one digit
+	data, err := json.Marshal(events(0, 1)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(data) + 1)
+
+	cases := []struct {
+		maxBytes int64
+		written  int // batches of one event that fit
+	}{
+		{size, 1},
+		{size*3 - 1, 2},
+		{size * 3, 3},
+		{size - 1, 0},
+	}
+
+	for _, c := range cases {
+		sink := &recordingSink{failing: true}
+		s, err := NewSpool("test", sink, t.TempDir(), c.maxBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		written := 0
+		for i := 0; i < 4; i++ {
+			if err := s.Write(events(i, i+1)); err == nil {
+				written++
+			}
+		}
+		s.Close()
+
+		if n, bytes := s.Depth(); written != c.written || n != int64(c.written) || bytes > c.maxBytes {
+			t.Errorf("max bytes %d: written %d depth %d events %d bytes, want %d", c.maxBytes, written, n, bytes, c.written)
+		}
+	}
+}
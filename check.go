@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"s3gw/ranger"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// CheckCase is a request to evaluate with s3gw check and, in a batch, its expected outcome
+type CheckCase struct {
+	Name       string
+	User       string
+	Groups     []string
+	Roles      []string
+	Owner      string
+	Location   string
+	AccessType string
+	Ip         string
+	Tags       map[string]string
+	Allowed    bool
+	Policy     *int // expected id of the deciding policy, -1 for none
+}
+
+// CheckCases is the batch file of s3gw check, a toml file with a [[case]] table per case
+type CheckCases struct {
+	Case []CheckCase
+}
+
+// request returns the access request of the case
+func (c *CheckCase) request() *ranger.AccessRequest {
+	location := c.Location
+	if !strings.HasPrefix(location, "/") {
+		location = "/" + location
+	}
+	bucket, key := GetBucketObjectKey(location)
+
+	return &ranger.AccessRequest{
+		User:       c.User,
+		UserGroups: c.Groups,
+		UserRoles:  c.Roles,
+		Resource: ranger.AccessResource{
+			Owner:    c.Owner,
+			Location: location,
+			Bucket:   bucket,
+			Key:      key,
+		},
+		AccessType:      c.AccessType,
+		AccessTime:      time.Now(),
+		ClientIpAddress: c.Ip,
+		RemoteIpAddress: c.Ip,
+		Tags:            ranger.ParseTags(c.Tags),
+	}
+}
+
+// verify returns why the result is not the expected outcome of the case, empty if it is
+func (c *CheckCase) verify(result *ranger.AccessResult) string {
+	if result.IsAllowed != c.Allowed {
+		return fmt.Sprintf("expected allowed=%t, got allowed=%t", c.Allowed, result.IsAllowed)
+	}
+	if c.Policy != nil && *c.Policy != result.PolicyId {
+		return fmt.Sprintf("expected policy=%d, got policy=%d", *c.Policy, result.PolicyId)
+	}
+
+	return ""
+}
+
+// loadService loads the policies from a policy file, as downloaded from Ranger or written
+// as policy cache, or live from the Ranger of the configuration
+func loadService(policyFile string, configFile string) (*ranger.Service, error) {
+	if policyFile != "" {
+		return ranger.ReadPolicyFile(policyFile)
+	}
+
+	config := ReadConfig(configFile)
+	client, err := newPolicyClient(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Download(nil)
+}
+
+// check runs the check subcommand, it evaluates a single request given by flags or a batch
+// of cases against the policies and returns the exit code
+func check(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	policyFile := flags.String("policies", "", "Ranger service json with the policies")
+	configFile := flags.String("conf", "", "configuration file to download the policies from Ranger with")
+	casesFile := flags.String("cases", "", "toml file with cases and their expected outcome")
+	user := flags.String("user", "", "user")
+	groups := flags.String("groups", "", "comma separated groups of the user")
+	roles := flags.String("roles", "", "comma separated roles of the user")
+	owner := flags.String("owner", "", "owner of the bucket")
+	location := flags.String("location", "", "location to access, /bucket/key")
+	accessType := flags.String("accessType", ranger.Read, "access type")
+	ip := flags.String("ip", "", "client ip address")
+	asJson := flags.Bool("json", false, "print the decision as json")
+	verbose := flags.Bool("v", false, "log the evaluation of the policies")
+	flags.Parse(args)
+
+	if *policyFile == "" && *configFile == "" {
+		fmt.Fprintln(os.Stderr, "Either -policies or -conf is required")
+		flags.Usage()
+		return 2
+	}
+
+	service, err := loadService(*policyFile, *configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot load policies: %s\n", err)
+		return 2
+	}
+
+	// policies that never match are a likely cause of unexpected outcomes
+	for _, problem := range service.Validate() {
+		fmt.Fprintf(os.Stderr, "Invalid %s\n", problem)
+	}
+
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	if *casesFile != "" {
+		return checkCases(service, *casesFile)
+	}
+
+	c := &CheckCase{
+		User:       *user,
+		Owner:      *owner,
+		Location:   *location,
+		AccessType: *accessType,
+		Ip:         *ip,
+	}
+	if *groups != "" {
+		c.Groups = strings.Split(*groups, ",")
+	}
+	if *roles != "" {
+		c.Roles = strings.Split(*roles, ",")
+	}
+	if c.User == "" || c.Location == "" {
+		fmt.Fprintln(os.Stderr, "Both -user and -location are required")
+		return 2
+	}
+
+	result := service.Evaluate(c.request())
+	if *asJson {
+		data, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		fmt.Printf("allowed=%t policy=%d version=%d service=%s serviceVersion=%d\n%s\n",
+			result.IsAllowed, result.PolicyId, result.PolicyVersion, result.ServiceName, result.ServiceVersion, result.Reason)
+	}
+
+	if !result.IsAllowed {
+		return 1
+	}
+	return 0
+}
+
+// checkCases evaluates the cases of the batch file and reports the ones that fail
+func checkCases(service *ranger.Service, path string) int {
+	var cases CheckCases
+	if _, err := toml.DecodeFile(path, &cases); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot decode cases: %s\n", err)
+		return 2
+	}
+
+	failed := 0
+	for i := range cases.Case {
+		c := &cases.Case[i]
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("case %d", i+1)
+		}
+
+		result := service.Evaluate(c.request())
+		if msg := c.verify(result); msg != "" {
+			failed++
+			fmt.Printf("FAIL %s: %s (%s)\n", name, msg, result.Reason)
+			continue
+		}
+		fmt.Printf("ok   %s: %s\n", name, result.Reason)
+	}
+
+	fmt.Printf("%d cases, %d failed, policy version=%d\n", len(cases.Case), failed, service.PolicyVersion)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
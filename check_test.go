@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const checkPolicies = `{"serviceName":"s3","policyVersion":4,"policies":[
+	{"id":1,"isEnabled":true,"resources":{"path":{"values":["/data"],"isRecursive":true}},
+	 "policyItems":[{"users":["bob"],"groups":["readers"],"accesses":[{"type":"read","isAllowed":true}]}]}]}`
+
+const checkCasesFile = `
+[[case]]
+name = "bob reads"
+user = "bob"
+location = "/data/a"
+accessType = "read"
+allowed = true
+policy = 1
+
+[[case]]
+name = "a reader reads"
+user = "alice"
+groups = ["readers"]
+location = "data/a"
+accessType = "read"
+allowed = true
+
+[[case]]
+user = "bob"
+location = "/logs/a"
+accessType = "read"
+allowed = false
+policy = -1
+`
+
+const checkFailingCase = `
+[[case]]
+name = "bob writes"
+user = "bob"
+location = "/data/a"
+accessType = "write"
+allowed = true
+`
+
+func TestCheck(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	write := func(name string, data string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	policyFile := write("policies.json", checkPolicies)
+	casesFile := write("cases.toml", checkCasesFile)
+	failingFile := write("failing.toml", checkFailingCase)
+	invalidFile := write("invalid.toml", "[[case]\n")
+
+	cases := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"allowed", []string{"-policies", policyFile, "-user", "bob", "-location", "/data/a"}, 0},
+		{"allowed by group", []string{"-policies", policyFile, "-user", "alice", "-groups", "x,readers", "-location", "/data/a", "-json"}, 0},
+		{"denied", []string{"-policies", policyFile, "-user", "bob", "-location", "/data/a", "-accessType", "write"}, 1},
+		{"cases", []string{"-policies", policyFile, "-cases", casesFile}, 0},
+		{"failing case", []string{"-policies", policyFile, "-cases", failingFile}, 1},
+		{"invalid cases", []string{"-policies", policyFile, "-cases", invalidFile}, 2},
+		{"missing cases", []string{"-policies", policyFile, "-cases", filepath.Join(dir, "missing.toml")}, 2},
+		{"no policies", []string{"-user", "bob", "-location", "/data/a"}, 2},
+		{"missing policies", []string{"-policies", filepath.Join(dir, "missing.json"), "-user", "bob", "-location", "/data/a"}, 2},
+		{"no user", []string{"-policies", policyFile, "-location", "/data/a"}, 2},
+		{"no location", []string{"-policies", policyFile, "-user", "bob"}, 2},
+	}
+
+	for _, c := range cases {
+		if code := check(c.args); code != c.code {
+			t.Errorf("%s: exit code %d, want %d", c.name, code, c.code)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"expvar"
+	"hash/fnv"
+	"log"
+	"s3gw/ranger"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// maxDecisionCacheTTL limits the seconds a decision is cached, as changes of bucket tags
+// made outside the gateway are not noticed before a decision expires
+const maxDecisionCacheTTL = 300
+
+var (
+	decisionStats         = expvar.NewMap("decisions")
+	decisionHits          = new(expvar.Int)
+	decisionMisses        = new(expvar.Int)
+	decisionBypassed      = new(expvar.Int)
+	decisionInvalidations = new(expvar.Int)
+)
+
+func init() {
+	decisionStats.Set("hits", decisionHits)
+	decisionStats.Set("misses", decisionMisses)
+	decisionStats.Set("bypassed", decisionBypassed)
+	decisionStats.Set("invalidations", decisionInvalidations)
+	decisionStats.Set("hitRate", expvar.Func(func() interface{} {
+		hits, misses := decisionHits.Value(), decisionMisses.Value()
+		if hits+misses == 0 {
+			return 0.0
+		}
+		return float64(hits) / float64(hits+misses)
+	}))
+}
+
+// DecisionCache is a bounded cache of the decisions of the policies. Decisions are only
+// valid for the policies, roles and user store they were made with and until the next
+// change of a validity schedule, they are dropped when the tags of their bucket or
+// object change through the gateway or the object tags differ from the tags they were
+// made with
+type DecisionCache struct {
+	ttl   time.Duration
+	cache *lru.Cache
+
+	// objectTags returns the current tags of an object, nil if object tags are disabled
+	objectTags func(bucket string, key string, version string) (map[string]string, error)
+
+	mu         sync.Mutex
+	service    *ranger.Service // policies the inputs are computed for
+	inputs     ranger.DecisionInputs
+	byBucket   map[string]map[string]bool // cache keys by bucket
+	byLocation map[string]map[string]bool // cache keys by location
+}
+
+// decision is a cached decision with the tags it was made with
+type decision struct {
+	result    ranger.AccessResult
+	tags      []ranger.Tag
+	object    map[string]string // object tags the decision was made with
+	service   *ranger.Service
+	roles     *ranger.Roles
+	userStore *ranger.UserStore
+	bucket    string
+	location  string
+	expires   time.Time
+}
+
+func NewDecisionCache(size int, ttl time.Duration) (*DecisionCache, error) {
+	c := &DecisionCache{
+		ttl:        ttl,
+		byBucket:   make(map[string]map[string]bool),
+		byLocation: make(map[string]map[string]bool),
+	}
+
+	cache, err := lru.NewWithEvict(size, c.unindex)
+	if err != nil {
+		return nil, err
+	}
+	c.cache = cache
+
+	return c, nil
+}
+
+// index and unindex keep track of the keys of the decisions on a bucket and location
+func (c *DecisionCache) index(key string, d *decision) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	addKey(c.byBucket, d.bucket, key)
+	addKey(c.byLocation, d.location, key)
+}
+
+func (c *DecisionCache) unindex(key interface{}, value interface{}) {
+	d := value.(*decision)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removeKey(c.byBucket, d.bucket, key.(string))
+	removeKey(c.byLocation, d.location, key.(string))
+}
+
+func addKey(index map[string]map[string]bool, name string, key string) {
+	if index[name] == nil {
+		index[name] = make(map[string]bool)
+	}
+	index[name][key] = true
+}
+
+func removeKey(index map[string]map[string]bool, name string, key string) {
+	delete(index[name], key)
+	if len(index[name]) == 0 {
+		delete(index, name)
+	}
+}
+
+// inputsOf returns what the decisions of the policies depend on, computed once per
+// version of the policies
+func (c *DecisionCache) inputsOf(service *ranger.Service) ranger.DecisionInputs {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.service != service {
+		c.service = service
+		c.inputs = service.DecisionInputs()
+		if c.inputs.Context {
+			log.Printf("Decisions of policies version=%d are not cached due to conditions on the request context\n",
+				service.PolicyVersion)
+		}
+	}
+
+	return c.inputs
+}
+
+// key returns the cache key of the request, false if its decision cannot be cached
+func (c *DecisionCache) key(service *ranger.Service, req *ranger.AccessRequest) (string, bool) {
+	inputs := c.inputsOf(service)
+	if inputs.Context {
+		return "", false
+	}
+
+	// the ip class are all addresses that ip conditions match against if the policies
+	// have them, all addresses are alike otherwise
+	ipClass := ""
+	if inputs.ClientIp {
+		ipClass = strings.Join(append([]string{req.ClientIpAddress, req.RemoteIpAddress}, req.ForwardedAdresses...), ",")
+	}
+
+	return strings.Join([]string{
+		req.User,
+		groupsHash(req.UserGroups),
+		req.Resource.Location,
+		req.AccessType,
+		ipClass,
+		strconv.Itoa(service.PolicyVersion),
+	}, "\x00"), true
+}
+
+// groupsHash returns a hash of the groups that does not depend on their order
+func groupsHash(groups []string) string {
+	sorted := append([]string(nil), groups...)
+	sort.Strings(sorted)
+
+	h := fnv.New64a()
+	for _, group := range sorted {
+		h.Write([]byte(group))
+		h.Write([]byte{0})
+	}
+
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// currentObjectTags returns the current tags of the object of the request, nil if there
+// is no object or object tags are disabled
+func (c *DecisionCache) currentObjectTags(req *ranger.AccessRequest, version string) (map[string]string, error) {
+	if c.objectTags == nil || len(req.Resource.Bucket) == 0 || len(req.Resource.Key) == 0 {
+		return nil, nil
+	}
+	return c.objectTags(req.Resource.Bucket, req.Resource.Key, version)
+}
+
+// Get returns the cached decision on the request of the object version and the tags it
+// was made with, false if there is none or it is not valid anymore. The object tags are
+// compared with the tag cache, so tags changed on RGW or through another gateway are
+// noticed as soon as the tag cache is
+func (c *DecisionCache) Get(service *ranger.Service, req *ranger.AccessRequest, version string) (*ranger.AccessResult, []ranger.Tag, bool) {
+	key, ok := c.key(service, req)
+	if !ok {
+		decisionBypassed.Add(1)
+		return nil, nil, false
+	}
+
+	if item, ok := c.cache.Get(key); ok {
+		d := item.(*decision)
+		if d.service == service && d.roles == policies.Roles() && d.userStore == policies.UserStore() &&
+			time.Now().Before(d.expires) && c.sameObjectTags(d, req, version) {
+			decisionHits.Add(1)
+			result := d.result
+			return &result, d.tags, true
+		}
+		c.cache.Remove(key)
+	}
+
+	decisionMisses.Add(1)
+	return nil, nil, false
+}
+
+// sameObjectTags checks if the object tags of the decision are still the current tags
+func (c *DecisionCache) sameObjectTags(d *decision, req *ranger.AccessRequest, version string) bool {
+	current, err := c.currentObjectTags(req, version)
+	if err != nil {
+		// the tags are loaded again to evaluate the request, which reports the error
+		return false
+	}
+	if len(current) != len(d.object) {
+		return false
+	}
+	for name, value := range current {
+		if stored, ok := d.object[name]; !ok || stored != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Add caches the decision on the request of the object version until the ttl passes or
+// a validity schedule of the policies changes, whatever is first
+func (c *DecisionCache) Add(service *ranger.Service, req *ranger.AccessRequest, version string, result *ranger.AccessResult) {
+	key, ok := c.key(service, req)
+	if !ok {
+		return
+	}
+
+	object, err := c.currentObjectTags(req, version)
+	if err != nil {
+		return
+	}
+
+	expires := time.Now().Add(c.ttl)
+	if next := service.NextValidityChange(req.AccessTime); !next.IsZero() && next.Before(expires) {
+		expires = next
+	}
+
+	d := &decision{
+		result:    *result,
+		tags:      append([]ranger.Tag{}, req.Tags...),
+		object:    object,
+		service:   service,
+		roles:     policies.Roles(),
+		userStore: policies.UserStore(),
+		bucket:    req.Resource.Bucket,
+		location:  req.Resource.Location,
+		expires:   expires,
+	}
+	c.index(key, d)
+	c.cache.Add(key, d)
+}
+
+// InvalidateBucket drops the decisions on the bucket and its objects, e.g. because the
+// tags of the bucket changed
+func (c *DecisionCache) InvalidateBucket(bucket string) {
+	c.remove(c.byBucket, bucket)
+}
+
+// InvalidateObject drops the decisions on the object, e.g. because its tags changed
+func (c *DecisionCache) InvalidateObject(location string) {
+	c.remove(c.byLocation, location)
+}
+
+func (c *DecisionCache) remove(index map[string]map[string]bool, name string) {
+	c.mu.Lock()
+	keys := make([]string, 0, len(index[name]))
+	for key := range index[name] {
+		keys = append(keys, key)
+	}
+	c.mu.Unlock()
+
+	if len(keys) > 0 {
+		decisionInvalidations.Add(1)
+	}
+	for _, key := range keys {
+		c.cache.Remove(key)
+	}
+}
+
+// Purge drops all decisions, e.g. because the credentials changed
+func (c *DecisionCache) Purge() {
+	decisionInvalidations.Add(1)
+	c.cache.Purge()
+}
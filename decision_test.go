@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"s3gw/ranger"
+	"testing"
+	"time"
+)
+
+func newTestRequest() *ranger.AccessRequest {
+	return &ranger.AccessRequest{
+		User:            "bob",
+		UserGroups:      []string{"users", "admins"},
+		AccessType:      "read",
+		ClientIpAddress: "10.1.2.3",
+		RemoteIpAddress: "10.1.2.4",
+		AccessTime:      time.Now(),
+		Resource:        ranger.AccessResource{Bucket: "data", Key: "a", Location: "/data/a"},
+		Tags:            []ranger.Tag{},
+	}
+}
+
+func TestDecisionCacheKey(t *testing.T) {
+	policies = &ranger.PolicyRefresher{}
+
+	ipPolicy := []ranger.Policy{{PolicyItems: []ranger.PolicyItem{{Conditions: []ranger.Condition{{Type: ranger.ConditionIpRange, Values: []string{"10.0.0.0/8"}}}}}}}
+	timePolicy := []ranger.Policy{{PolicyItems: []ranger.PolicyItem{{Conditions: []ranger.Condition{{Type: ranger.ConditionTimeOfDay, Values: []string{"9am-5pm"}}}}}}}
+
+	cases := []struct {
+		name     string
+		policies []ranger.Policy
+		change   func(req *ranger.AccessRequest)
+		hit      bool
+	}{
+		{"same", nil, func(req *ranger.AccessRequest) {}, true},
+		{"group order", nil, func(req *ranger.AccessRequest) { req.UserGroups = []string{"admins", "users"} }, true},
+		{"other user", nil, func(req *ranger.AccessRequest) { req.User = "alice" }, false},
+		{"other groups", nil, func(req *ranger.AccessRequest) { req.UserGroups = []string{"users"} }, false},
+		{"other location", nil, func(req *ranger.AccessRequest) { req.Resource.Location = "/data/b" }, false},
+		{"other access type", nil, func(req *ranger.AccessRequest) { req.AccessType = "write" }, false},
+		{"other ip without ip conditions", nil, func(req *ranger.AccessRequest) { req.ClientIpAddress = "192.168.1.1" }, true},
+		{"same ips", ipPolicy, func(req *ranger.AccessRequest) {}, true},
+		{"other client ip", ipPolicy, func(req *ranger.AccessRequest) { req.ClientIpAddress = "192.168.1.1" }, false},
+		{"other remote ip", ipPolicy, func(req *ranger.AccessRequest) { req.RemoteIpAddress = "192.168.1.1" }, false},
+		{"forwarded", ipPolicy, func(req *ranger.AccessRequest) { req.ForwardedAdresses = []string{"192.168.1.1"} }, false},
+		{"context conditions", timePolicy, func(req *ranger.AccessRequest) {}, false},
+	}
+
+	for _, c := range cases {
+		cache, err := NewDecisionCache(16, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		service := &ranger.Service{PolicyVersion: 1, Policies: c.policies}
+
+		cache.Add(service, newTestRequest(), "", &ranger.AccessResult{IsAllowed: true})
+		req := newTestRequest()
+		c.change(req)
+		result, _, hit := cache.Get(service, req, "")
+		if hit != c.hit {
+			t.Errorf("%s: hit = %v, want %v", c.name, hit, c.hit)
+		}
+		if hit && !result.IsAllowed {
+			t.Errorf("%s: cached decision is not allowed", c.name)
+		}
+	}
+}
+
+func TestDecisionCacheInvalidation(t *testing.T) {
+	policies = &ranger.PolicyRefresher{}
+	service := &ranger.Service{PolicyVersion: 1}
+
+	cases := []struct {
+		name       string
+		service    *ranger.Service
+		ttl        time.Duration
+		invalidate func(c *DecisionCache)
+	}{
+		{"other policies", &ranger.Service{PolicyVersion: 1}, time.Minute, func(c *DecisionCache) {}},
+		{"expired", service, -time.Second, func(c *DecisionCache) {}},
+		{"object", service, time.Minute, func(c *DecisionCache) { c.InvalidateObject("/data/a") }},
+		{"bucket", service, time.Minute, func(c *DecisionCache) { c.InvalidateBucket("data") }},
+		{"purge", service, time.Minute, func(c *DecisionCache) { c.Purge() }},
+	}
+
+	for _, c := range cases {
+		cache, err := NewDecisionCache(16, c.ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cache.Add(service, newTestRequest(), "", &ranger.AccessResult{IsAllowed: true})
+		c.invalidate(cache)
+		if _, _, hit := cache.Get(c.service, newTestRequest(), ""); hit {
+			t.Errorf("%s: decision is still cached", c.name)
+		}
+		if len(cache.byBucket) != 0 || len(cache.byLocation) != 0 {
+			t.Errorf("%s: index is not empty %v %v", c.name, cache.byBucket, cache.byLocation)
+		}
+	}
+}
+
+func TestDecisionCacheEviction(t *testing.T) {
+	policies = &ranger.PolicyRefresher{}
+	service := &ranger.Service{PolicyVersion: 1}
+
+	cache, err := NewDecisionCache(2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, location := range []string{"/data/a", "/data/b", "/data/c"} {
+		req := newTestRequest()
+		req.Resource.Location = location
+		cache.Add(service, req, "", &ranger.AccessResult{})
+	}
+
+	if len(cache.byLocation) != 2 || cache.byLocation["/data/a"] != nil {
+		t.Errorf("evicted decisions are still indexed %v", cache.byLocation)
+	}
+	if len(cache.byBucket["data"]) != 2 {
+		t.Errorf("bucket index %v, want 2 keys", cache.byBucket)
+	}
+}
+
+func TestDecisionCacheObjectTags(t *testing.T) {
+	policies = &ranger.PolicyRefresher{}
+	service := &ranger.Service{PolicyVersion: 1}
+
+	tags := map[string]map[string]string{"": {"PII": "yes"}, "v1": {}}
+	var tagErr error
+
+	cases := []struct {
+		name    string
+		version string
+		change  func()
+		hit     bool
+	}{
+		{"unchanged", "", func() {}, true},
+		{"changed value", "", func() { tags[""] = map[string]string{"PII": "no"} }, false},
+		{"added tag", "", func() { tags[""] = map[string]string{"PII": "yes", "owner": "bob"} }, false},
+		{"removed tags", "", func() { tags[""] = map[string]string{} }, false},
+		{"other version", "v1", func() {}, false},
+		{"lookup error", "", func() { tagErr = errors.New("unavailable") }, false},
+	}
+
+	for _, c := range cases {
+		tags[""], tagErr = map[string]string{"PII": "yes"}, nil
+		cache, err := NewDecisionCache(16, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.objectTags = func(bucket string, key string, version string) (map[string]string, error) {
+			return tags[version], tagErr
+		}
+
+		cache.Add(service, newTestRequest(), "", &ranger.AccessResult{IsAllowed: true})
+		c.change()
+		if _, _, hit := cache.Get(service, newTestRequest(), c.version); hit != c.hit {
+			t.Errorf("%s: hit = %v, want %v", c.name, hit, c.hit)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"s3gw/ranger"
+	"s3gw/s3"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of the context attributes set by the enrichers, available to context-attribute
+// conditions and the ctx. identifiers of expressions
+const (
+	contextClientType     = "clientType"
+	contextUserAgent      = "userAgent"
+	contextSessionId      = "sessionId"
+	contextRequestData    = "requestData"
+	contextClusterName    = "clusterName"
+	contextHeaderPrefix   = "header."
+	contextMetadataPrefix = "meta."
+
+	// invocationIdHeader identifies a request of the aws sdks, including retries
+	invocationIdHeader   = "Amz-Sdk-Invocation-Id"
+	metadataHeaderPrefix = "X-Amz-Meta-"
+)
+
+// errInvalidRequest is returned by enrichers for requests that cannot be evaluated
+var errInvalidRequest = errors.New("Invalid request")
+
+// Enricher adds context to the access request of a request before it is evaluated. It
+// returns errInvalidRequest if the request is invalid and any other error if the context
+// cannot be loaded, the request is refused then as it cannot be evaluated reliably
+type Enricher interface {
+	Enrich(req *ranger.AccessRequest, r *http.Request) error
+}
+
+type EnricherFunc func(req *ranger.AccessRequest, r *http.Request) error
+
+func (f EnricherFunc) Enrich(req *ranger.AccessRequest, r *http.Request) error {
+	return f(req, r)
+}
+
+var enrichers []Enricher
+
+// RegisterEnricher adds an enricher that runs after the enrichers registered before, it
+// must be called before the gateway serves requests
+func RegisterEnricher(e Enricher) {
+	enrichers = append(enrichers, e)
+}
+
+// enrich runs all enrichers on the access request
+func enrich(req *ranger.AccessRequest, r *http.Request) error {
+	if req.Context == nil {
+		req.Context = make(map[string]interface{})
+	}
+
+	for _, e := range enrichers {
+		if err := e.Enrich(req, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// registerEnrichers registers the built-in enrichers for the configuration
+func registerEnrichers(config *Config) {
+	RegisterEnricher(EnricherFunc(enrichClient))
+	RegisterEnricher(EnricherFunc(enrichSession))
+	RegisterEnricher(EnricherFunc(enrichRequestData))
+
+	if clusterName := config.Ranger.ClusterName; clusterName != "" {
+		RegisterEnricher(EnricherFunc(func(req *ranger.AccessRequest, r *http.Request) error {
+			req.ClusterName = clusterName
+			req.Context[contextClusterName] = clusterName
+			return nil
+		}))
+	}
+
+	if headers := config.Enrich.Headers; len(headers) > 0 {
+		RegisterEnricher(EnricherFunc(func(req *ranger.AccessRequest, r *http.Request) error {
+			for _, name := range headers {
+				if value := r.Header.Get(name); value != "" {
+					req.Context[contextHeaderPrefix+strings.ToLower(name)] = value
+				}
+			}
+			return nil
+		}))
+	}
+
+	RegisterEnricher(EnricherFunc(enrichTags))
+
+	if config.Enrich.ObjectMetadata {
+		RegisterEnricher(EnricherFunc(enrichMetadata))
+	}
+}
+
+// serviceEnrichers maps the context enrichers of Ranger service definitions, by the name
+// of their class, to the configuration that provides the same context in the gateway
+var serviceEnrichers = map[string]string{
+	"RangerTagEnricher":       "tags",
+	"RangerUserStoreEnricher": "ranger.syncuserstore",
+}
+
+// checkServiceEnrichers logs the context enrichers of the service definition that the
+// gateway does not provide, conditions that depend on them cannot match
+func checkServiceEnrichers(service *ranger.Service) {
+	if service == nil {
+		return
+	}
+
+	for _, def := range service.ServiceDef.ContextEnrichers {
+		class := def.Enricher[strings.LastIndex(def.Enricher, ".")+1:]
+		if option, ok := serviceEnrichers[class]; ok {
+			log.Printf("Context enricher name=%s of service=%s is provided by %s\n", def.Name, service.ServiceName, option)
+		} else {
+			log.Printf("Context enricher name=%s class=%s of service=%s is not supported\n", def.Name, def.Enricher, service.ServiceName)
+		}
+	}
+}
+
+// enrichClient sets the client type from the product of the User-Agent, e.g. aws-cli
+// for aws-cli/2.0.0 Python/3.7.4
+func enrichClient(req *ranger.AccessRequest, r *http.Request) error {
+	agent := r.UserAgent()
+	fields := strings.Fields(agent)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	product := fields[0]
+	if pos := strings.Index(product, "/"); pos > 0 {
+		product = product[:pos]
+	}
+
+	req.ClientType = product
+	req.Context[contextClientType] = product
+	req.Context[contextUserAgent] = agent
+
+	return nil
+}
+
+// enrichSession sets the session id to the invocation id of the aws sdks, which is the
+// same for retries of a request, or a new id
+func enrichSession(req *ranger.AccessRequest, r *http.Request) error {
+	id := r.Header.Get(invocationIdHeader)
+	if id == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			id = strconv.FormatInt(time.Now().UnixNano(), 16)
+		} else {
+			id = hex.EncodeToString(b)
+		}
+	}
+
+	req.SessionId = id
+	req.Context[contextSessionId] = id
+
+	return nil
+}
+
+// enrichRequestData sets the request data to the method and uri of the request
+func enrichRequestData(req *ranger.AccessRequest, r *http.Request) error {
+	req.RequestData = r.Method + " " + r.URL.RequestURI()
+	req.Context[contextRequestData] = req.RequestData
+
+	return nil
+}
+
+// enrichTags sets the tags of the bucket and, if object tags are enabled, of the object
+// including the tags of an upload
+func enrichTags(req *ranger.AccessRequest, r *http.Request) error {
+	bucket, key := req.Resource.Bucket, req.Resource.Key
+	if len(bucket) == 0 || req.Tags != nil {
+		// no bucket or the tags of a cached decision
+		return nil
+	}
+
+	// tag based denies would be bypassed without the tags
+	err, tagSet := s3Client.GetBucketTags(bucket)
+	if err != nil && !s3.IsNoTags(err) {
+		log.Printf("Cannot load tags for bucket=%s due to error=%s\n", bucket, err)
+		return err
+	}
+	tags := ranger.ParseTags(s3.TagMap(tagSet))
+
+	if tagCache != nil && len(key) > 0 {
+		cached, err := tagCache.GetObjectTags(bucket, key, r.URL.Query().Get("versionId"))
+		if err != nil {
+			log.Printf("Cannot load tags for bucket=%s key=%s due to error=%s\n", bucket, key, err)
+			return err
+		}
+		objectTags := make(map[string]string)
+		for name, value := range cached {
+			objectTags[name] = value
+		}
+		if tagging := r.Header.Get("x-amz-tagging"); len(tagging) > 0 {
+			uploadTags, err := s3.ParseTagging(tagging)
+			if err != nil {
+				log.Printf("Invalid x-amz-tagging=%s for bucket=%s key=%s\n", tagging, bucket, key)
+				return errInvalidRequest
+			}
+			for name, value := range uploadTags {
+				objectTags[name] = value
+			}
+		}
+		tags = append(tags, ranger.ParseTags(objectTags)...)
+	}
+	req.Tags = tags
+	log.Printf("Tags: %v", req.Tags)
+
+	return nil
+}
+
+// enrichMetadata sets the user metadata of the object, including the metadata of an
+// upload, as context attributes
+func enrichMetadata(req *ranger.AccessRequest, r *http.Request) error {
+	bucket, key := req.Resource.Bucket, req.Resource.Key
+	if len(bucket) == 0 || len(key) == 0 {
+		return nil
+	}
+
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		err, metadata := s3Client.GetObjectMetadata(bucket, key, r.URL.Query().Get("versionId"))
+		if err != nil {
+			// the object may not exist
+			return nil
+		}
+		for name, value := range metadata {
+			req.Context[contextMetadataPrefix+name] = value
+		}
+		return nil
+	}
+
+	for name, values := range r.Header {
+		if strings.HasPrefix(name, metadataHeaderPrefix) && len(values) > 0 {
+			req.Context[contextMetadataPrefix+strings.ToLower(name[len(metadataHeaderPrefix):])] = values[0]
+		}
+	}
+
+	return nil
+}
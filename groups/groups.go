@@ -0,0 +1,103 @@
+package groups
+
+import (
+	"expvar"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+var metrics = expvar.NewMap("groups")
+
+// LookupErrors counts failed lookups of the groups of a user and of single groups
+var LookupErrors = expvar.NewInt("group_lookup_errors")
+
+// GroupProvider resolves the groups of a user. A user that is unknown to the provider
+// has no groups, errors are only returned if the groups cannot be determined
+type GroupProvider interface {
+	Groups(user string) ([]string, error)
+}
+
+// MultiProvider returns the groups of all its providers
+type MultiProvider []GroupProvider
+
+func (m MultiProvider) Groups(user string) ([]string, error) {
+	var groups []string
+	for _, provider := range m {
+		found, err := provider.Groups(user)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, found...)
+	}
+
+	return unique(groups), nil
+}
+
+// CachedProvider caches the groups of a provider for a while. Failed lookups are not
+// cached so that they are retried by the next request
+type CachedProvider struct {
+	provider GroupProvider
+	cache    *cache.Cache
+}
+
+func NewCachedProvider(provider GroupProvider, ttl time.Duration) *CachedProvider {
+	return &CachedProvider{
+		provider: provider,
+		cache:    cache.New(ttl, 2*ttl),
+	}
+}
+
+func (c *CachedProvider) Groups(user string) ([]string, error) {
+	if item, found := c.cache.Get(user); found {
+		metrics.Add("hits", 1)
+		return item.([]string), nil
+	}
+	metrics.Add("misses", 1)
+
+	groups, err := c.provider.Groups(user)
+	if err != nil {
+		metrics.Add("errors", 1)
+		return nil, err
+	}
+	c.cache.SetDefault(user, groups)
+
+	return groups, nil
+}
+
+// Invalidate removes the groups of all users from the cache
+func (c *CachedProvider) Invalidate() {
+	c.cache.Flush()
+}
+
+// unique sorts the groups and removes duplicates
+func unique(groups []string) []string {
+	sort.Strings(groups)
+
+	result := groups[:0]
+	for i, group := range groups {
+		if i == 0 || group != groups[i-1] {
+			result = append(result, group)
+		}
+	}
+
+	return result
+}
+
+// commonName returns the value of the first rdn of a dn, e.g. analysts for
+// cn=analysts,ou=groups,dc=example,dc=com. Anything else is returned as is
+func commonName(dn string) string {
+	pos := strings.Index(dn, "=")
+	if pos < 0 {
+		return dn
+	}
+
+	value := dn[pos+1:]
+	if end := strings.Index(value, ","); end >= 0 {
+		value = value[:end]
+	}
+
+	return value
+}
@@ -0,0 +1,103 @@
+package groups
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeProvider returns fixed groups and counts its lookups
+type fakeProvider struct {
+	groups  map[string][]string
+	err     error
+	lookups int
+}
+
+func (p *fakeProvider) Groups(user string) ([]string, error) {
+	p.lookups++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.groups[user], nil
+}
+
+func TestMultiProvider(t *testing.T) {
+	first := &fakeProvider{groups: map[string][]string{"bob": {"users", "analysts"}}}
+	second := &fakeProvider{groups: map[string][]string{"bob": {"users", "admins"}, "alice": {"admins"}}}
+	failing := &fakeProvider{err: errors.New("Directory unavailable")}
+
+	cases := []struct {
+		name      string
+		providers MultiProvider
+		user      string
+		want      []string
+		err       bool
+	}{
+		{"merged", MultiProvider{first, second}, "bob", []string{"admins", "analysts", "users"}, false},
+		{"one provider", MultiProvider{first, second}, "alice", []string{"admins"}, false},
+		{"unknown", MultiProvider{first, second}, "carol", []string{}, false},
+		{"no providers", MultiProvider{}, "bob", []string{}, false},
+		{"failing", MultiProvider{first, failing}, "bob", nil, true},
+	}
+
+	for _, c := range cases {
+		groups, err := c.providers.Groups(c.user)
+		if (err != nil) != c.err || fmt.Sprint(groups) != fmt.Sprint(c.want) {
+			t.Errorf("%s: groups %v error %v, want %v", c.name, groups, err, c.want)
+		}
+	}
+}
+
+func TestCachedProvider(t *testing.T) {
+	provider := &fakeProvider{groups: map[string][]string{"bob": {"users"}}}
+	cached := NewCachedProvider(provider, time.Minute)
+
+	cases := []struct {
+		name       string
+		user       string
+		err        error
+		invalidate bool
+		lookups    int // lookups of the provider so far
+	}{
+		{"miss", "bob", nil, false, 1},
+		{"hit", "bob", nil, false, 1},
+		{"other user", "alice", nil, false, 2},
+		{"invalidated", "bob", nil, true, 3},
+		{"failed", "carol", errors.New("Directory unavailable"), false, 4},
+		{"failure not cached", "carol", nil, false, 5},
+		{"cached after failure", "carol", nil, false, 5},
+	}
+
+	for _, c := range cases {
+		provider.err = c.err
+		if c.invalidate {
+			cached.Invalidate()
+		}
+
+		groups, err := cached.Groups(c.user)
+		if (err != nil) != (c.err != nil) {
+			t.Errorf("%s: error %v, want %v", c.name, err, c.err)
+		}
+		if err == nil && fmt.Sprint(groups) != fmt.Sprint(provider.groups[c.user]) {
+			t.Errorf("%s: groups %v, want %v", c.name, groups, provider.groups[c.user])
+		}
+		if provider.lookups != c.lookups {
+			t.Errorf("%s: %d lookups, want %d", c.name, provider.lookups, c.lookups)
+		}
+	}
+}
+
+func TestCommonName(t *testing.T) {
+	cases := map[string]string{
+		"cn=analysts,ou=groups,dc=example,dc=com": "analysts",
+		"cn=admins": "admins",
+		"analysts":  "analysts",
+	}
+
+	for dn, want := range cases {
+		if got := commonName(dn); got != want {
+			t.Errorf("commonName(%s) = %s, want %s", dn, got, want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package groups
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	defaultUserFilter         = "(uid=%s)"
+	defaultGroupAttribute     = "memberOf"
+	defaultGroupNameAttribute = "cn"
+)
+
+// LDAPProvider resolves groups from a directory. The groups are read from the group
+// attribute (memberOf) of the user entry and, if a group filter is set, searched for
+// with it, e.g. (&(objectClass=posixGroup)(memberUid=%s)). All lookups share one
+// connection, which is reopened if it fails
+type LDAPProvider struct {
+	Url                string
+	BindDN             string
+	BindPassword       string
+	BaseDN             string
+	UserFilter         string // %s is replaced by the user name
+	GroupAttribute     string
+	GroupBaseDN        string // defaults to BaseDN
+	GroupFilter        string // %s is replaced by the user name
+	GroupNameAttribute string
+	StartTLS           bool
+	TLSConfig          *tls.Config
+
+	mu   sync.Mutex
+	conn *ldap.Conn
+}
+
+func NewLDAPProvider(url string, baseDN string) *LDAPProvider {
+	return &LDAPProvider{
+		Url:                url,
+		BaseDN:             baseDN,
+		UserFilter:         defaultUserFilter,
+		GroupAttribute:     defaultGroupAttribute,
+		GroupNameAttribute: defaultGroupNameAttribute,
+	}
+}
+
+func (p *LDAPProvider) connect() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(p.Url, ldap.DialWithTLSConfig(p.TLSConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	if p.StartTLS {
+		if err = conn.StartTLS(p.TLSConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if p.BindDN != "" {
+		if err = conn.Bind(p.BindDN, p.BindPassword); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// connection returns the shared connection, it is opened if there is none
+func (p *LDAPProvider) connection() (*ldap.Conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn != nil && !p.conn.IsClosing() {
+		return p.conn, nil
+	}
+
+	conn, err := p.connect()
+	if err != nil {
+		return nil, err
+	}
+	p.conn = conn
+
+	return conn, nil
+}
+
+// drop closes a failed connection, the next lookup opens a new one
+func (p *LDAPProvider) drop(conn *ldap.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == conn {
+		p.conn = nil
+	}
+	conn.Close()
+}
+
+// isConnectionError checks if a lookup failed due to the connection, rather than the
+// search, in which case it is retried with a new connection
+func isConnectionError(conn *ldap.Conn, err error) bool {
+	return conn.IsClosing() || ldap.IsErrorAnyOf(err, ldap.ErrorNetwork, ldap.LDAPResultUnavailable)
+}
+
+func (p *LDAPProvider) Groups(user string) ([]string, error) {
+	if p.BaseDN == "" {
+		return nil, errors.New("No base dn configured for ldap")
+	}
+
+	conn, err := p.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := p.lookup(conn, user)
+	if err != nil && isConnectionError(conn, err) {
+		// e.g. the directory closed an idle connection
+		p.drop(conn)
+		if conn, err = p.connection(); err != nil {
+			return nil, err
+		}
+		if groups, err = p.lookup(conn, user); err != nil && isConnectionError(conn, err) {
+			p.drop(conn)
+		}
+	}
+
+	return groups, err
+}
+
+// lookup searches the groups of the user on the connection
+func (p *LDAPProvider) lookup(conn *ldap.Conn, user string) ([]string, error) {
+	name := ldap.EscapeFilter(user)
+	var groups []string
+
+	if p.GroupAttribute != "" {
+		result, err := conn.Search(ldap.NewSearchRequest(
+			p.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+			fmt.Sprintf(p.UserFilter, name), []string{p.GroupAttribute}, nil))
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Entries) > 1 {
+			return nil, fmt.Errorf("Multiple ldap entries found for user=%s", user)
+		}
+		for _, entry := range result.Entries {
+			for _, dn := range entry.GetAttributeValues(p.GroupAttribute) {
+				groups = append(groups, commonName(dn))
+			}
+		}
+	}
+
+	if p.GroupFilter != "" {
+		baseDN := p.GroupBaseDN
+		if baseDN == "" {
+			baseDN = p.BaseDN
+		}
+		result, err := conn.Search(ldap.NewSearchRequest(
+			baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			fmt.Sprintf(p.GroupFilter, name), []string{p.GroupNameAttribute}, nil))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range result.Entries {
+			if group := entry.GetAttributeValue(p.GroupNameAttribute); group != "" {
+				groups = append(groups, group)
+			}
+		}
+	}
+
+	return unique(groups), nil
+}
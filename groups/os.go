@@ -0,0 +1,38 @@
+package groups
+
+import (
+	"log"
+	"os/user"
+)
+
+// OSProvider resolves groups from the passwd and group databases of the host
+type OSProvider struct{}
+
+func (p *OSProvider) Groups(username string) ([]string, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		if _, ok := err.(user.UnknownUserError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	gids, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+
+	// a stale gid must not lock the user out, it has the groups that can be resolved
+	var groups []string
+	for _, gid := range gids {
+		group, err := user.LookupGroupId(gid)
+		if err != nil {
+			log.Printf("Cannot resolve gid=%s of user=%s due to error %s\n", gid, username, err)
+			LookupErrors.Add(1)
+			continue
+		}
+		groups = append(groups, group.Name)
+	}
+
+	return groups, nil
+}
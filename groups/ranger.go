@@ -0,0 +1,100 @@
+package groups
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"s3gw/ranger"
+	"time"
+)
+
+const userEndpoint = "/service/xusers/users/userName/"
+
+// RangerProvider resolves groups from the user store of Ranger admin
+type RangerProvider struct {
+	BaseUrls   []string // Ranger admin urls, the next one is tried if one fails
+	HTTPClient *http.Client
+	Auth       ranger.Authenticator
+}
+
+func NewRangerProvider(baseUrls []string) *RangerProvider {
+	return &RangerProvider{
+		BaseUrls:   baseUrls,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// UserStoreProvider resolves groups from the user store that is synced from Ranger admin
+type UserStoreProvider struct {
+	Store func() *ranger.UserStore
+}
+
+func (p *UserStoreProvider) Groups(user string) ([]string, error) {
+	return p.Store().Groups(user)
+}
+
+// vxUser is the part of a user of the Ranger user store that is used
+type vxUser struct {
+	Name          string
+	GroupNameList []string
+}
+
+func (p *RangerProvider) Groups(user string) ([]string, error) {
+	if len(p.BaseUrls) == 0 {
+		return nil, errors.New("No Ranger urls configured for groups")
+	}
+
+	var err error
+	for _, baseUrl := range p.BaseUrls {
+		var groups []string
+		groups, err = p.groupsFrom(baseUrl, user)
+		if err == nil {
+			return groups, nil
+		}
+	}
+
+	return nil, err
+}
+
+func (p *RangerProvider) groupsFrom(baseUrl string, user string) ([]string, error) {
+	req, err := http.NewRequest("GET", baseUrl+userEndpoint+url.PathEscape(user), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	if p.Auth != nil {
+		if err = p.Auth.Authenticate(req); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusNoContent:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("Ranger returned status=%d for user=%s", resp.StatusCode, user)
+	}
+
+	var u vxUser
+	if err = json.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+
+	return u.GroupNameList, nil
+}
@@ -0,0 +1,65 @@
+package groups
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// mapping is the content of a group mapping file
+type mapping struct {
+	Users  map[string][]string `yaml:"users"`  // groups by user
+	Groups map[string][]string `yaml:"groups"` // users by group
+}
+
+// StaticProvider resolves groups from a mapping file. The file is yaml if it ends with
+// .yaml or .yml and toml otherwise, it maps users to groups and/or groups to users:
+//
+//	[users]
+//	bob = ["analysts", "users"]
+//
+//	[groups]
+//	admins = ["alice"]
+type StaticProvider struct {
+	groups map[string][]string
+}
+
+func NewStaticProvider(path string) (*StaticProvider, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m mapping
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &m)
+	default:
+		err = toml.Unmarshal(data, &m)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p := &StaticProvider{groups: make(map[string][]string)}
+	for user, groups := range m.Users {
+		p.groups[user] = append(p.groups[user], groups...)
+	}
+	for group, users := range m.Groups {
+		for _, user := range users {
+			p.groups[user] = append(p.groups[user], group)
+		}
+	}
+	for user := range p.groups {
+		p.groups[user] = unique(p.groups[user])
+	}
+
+	return p, nil
+}
+
+func (p *StaticProvider) Groups(user string) ([]string, error) {
+	return p.groups[user], nil
+}
@@ -0,0 +1,54 @@
+package groups
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestStaticProvider(t *testing.T) {
+	cases := []struct {
+		file string
+		data string
+		err  bool
+	}{
+		{"groups.yaml", "users:\n  bob: [users, analysts]\ngroups:\n  admins: [bob, alice]\n", false},
+		{"groups.yml", "groups:\n  admins: [bob, alice]\nusers:\n  bob: [analysts, users, admins]\n", false},
+		{"groups.toml", "[users]\nbob = [\"users\", \"analysts\"]\n\n[groups]\nadmins = [\"bob\", \"alice\"]\n", false},
+		{"groups.conf", "[users]\nbob = [\"users\", \"analysts\", \"admins\"]\nalice = [\"admins\"]\n", false},
+		{"invalid.toml", "[users\n", true},
+		{"invalid.yaml", "users: [\n", true},
+	}
+
+	want := map[string]string{
+		"bob":   "[admins analysts users]",
+		"alice": "[admins]",
+		"carol": "[]",
+	}
+
+	for _, c := range cases {
+		path := filepath.Join(t.TempDir(), c.file)
+		if err := ioutil.WriteFile(path, []byte(c.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := NewStaticProvider(path)
+		if (err != nil) != c.err {
+			t.Errorf("%s: error %v", c.file, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		for user, groups := range want {
+			if got, err := p.Groups(user); err != nil || fmt.Sprint(got) != groups {
+				t.Errorf("%s: groups of %s %v error %v, want %s", c.file, user, got, err, groups)
+			}
+		}
+	}
+
+	if _, err := NewStaticProvider(filepath.Join(t.TempDir(), "missing.toml")); err == nil {
+		t.Error("missing file: no error")
+	}
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"net/url"
 	"net/http"
 	"strings"
 	"log"
-	"os/user"
+	"path"
+	"strconv"
+	"expvar"
+	"s3gw/audit"
+	"s3gw/groups"
 	"s3gw/ranger"
 	"time"
+	"sync/atomic"
 	"net/http/httputil"
 )
 
@@ -20,15 +26,28 @@ type Transport struct {
 
 }
 
+// policyIdHeader carries the id of the policy that denied a request
+const policyIdHeader = "X-S3gw-Policy-Id"
+
+// reasonAuditOnly marks the audit events of denials that were not enforced
+const reasonAuditOnly = "audit-only"
+
+var auditOnlyDenials = expvar.NewInt("audit_only_denials")
+var groupLookupErrors = groups.LookupErrors
+
 func NewProxy(target string) *Proxy {
 	u, _ := url.Parse(target)
 
-	return &Proxy{target: u, proxy: httputil.NewSingleHostReverseProxy(u)}
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	proxy.Transport = &Transport{}
+
+	return &Proxy{target: u, proxy: proxy}
 }
 
 func (p *Proxy) handle(w http.ResponseWriter, r *http.Request){
 	header := r.Header.Get("Authorization")
-	p.proxy.Transport = &Transport{}
+	record := &requestRecord{Start: time.Now()}
+	w = &responseRecorder{ResponseWriter: w, record: record}
 
 	var username string
 	var fwdAddresses []string
@@ -69,119 +88,53 @@ func (p *Proxy) handle(w http.ResponseWriter, r *http.Request){
 		return
 	}
 
-	o, k := GetBucketObjectKey(r.URL.String())
-	location := "/" + o
-	if len(k) > 0 {
-		location += "/" + k
-	}
-
-	// get owner of the bucket
-	owner := ""
-	if len(o) > 0 {
-		item, found := ownerCache.Get(o)
-		if !found {
-			log.Printf("Cached owner not found for bucket=%s\n", o)
-			item, _ = radosClient.GetBucketOwner(o)
-			ownerCache.SetDefault(o, item)
-		}
-		owner = item.(string)
-	}
-
-	// load groups of the user from local system
-	my_user, err := user.Lookup(username)
-	var groups []string
-
-	if err == nil {
-		gids, _ := my_user.GroupIds()
-		for _, gid := range gids {
-			groupName, _ := user.LookupGroupId(gid)
-			groups = append(groups, groupName.Name)
-		}
-	}
+	o, k := GetBucketObjectKey(r.URL.Path)
+	query := r.URL.Query()
+	location := locationOf(o, k)
 
-	// get tags for this bucket
-	err, tags := s3Client.GetBucketTags(location)
+	// load groups of the user, policies cannot be evaluated reliably without them
+	groups, err := groupProvider.Groups(username)
 	if err != nil {
-		log.Printf("Cannot load tags for bucket=%s due to error=%s\n", location, err)
+		log.Printf("Cannot resolve groups for user=%s due to error %s\n", username, err)
+		groupLookupErrors.Add(1)
+		http.Error(w, "Cannot resolve groups", http.StatusServiceUnavailable)
+		return
 	}
-	log.Printf("Tags: %s", tags)
 
 	log.Printf("user=%s, bucket=%s, key=%s, method=%s\n", username, o, k, r.Method)
 
-	query := r.URL.Query()
+	req := newAccessRequest(r.Context(), username, groups, o, k, "")
+	req.Action = r.Method
+	req.RemoteIpAddress = remoteAddress
+	req.ClientIpAddress = clientIp
+	req.ForwardedAdresses = fwdAddresses
+	req.Headers = r.Header
 
-	req := &ranger.AccessRequest{
-		User: username,
-		UserGroups: groups,
-		Resource: ranger.AccessResource{
-			Owner: owner,
-			Location: location,
-		},
-		Action:          	r.Method,
-		AccessTime:      	time.Now(),
-		RemoteIpAddress: 	remoteAddress,
-		ClientIpAddress: 	clientIp,
-		ForwardedAdresses:	fwdAddresses,
-	}
+	service := policies.Service()
 
 	switch strings.ToUpper(r.Method) {
 	case "DELETE":
-		if len(query.Get("uploadId")) > 0 {
+		if _, ok := query["uploadId"]; ok {
 			log.Printf("Skip notification for multipart ABORT")
 		}
 		req.AccessType = ranger.Write
-		if !service.IsAccessAllowed(req) {
-			log.Printf("Access denied location=%s, user=%s, groups=%s, accessType=%s",
-				location, username, groups, "write")
-			http.Error(w, "Access denied", http.StatusForbidden)
-			return
-		}
-		break
 	case "HEAD":
 		req.AccessType = ranger.Read
-		if !service.IsAccessAllowed(req) {
-			log.Printf("Access denied location=%s, user=%s, groups=%s, accessType=%s",
-				location, username, groups, "read")
-			http.Error(w, "Access denied", http.StatusForbidden)
-			return
-		}
-		break
 	case "PUT":
 		req.AccessType = ranger.Write
-		if len(query.Get("acl")) > 0 {
+		if _, ok := query["acl"]; ok {
 			req.AccessType = ranger.WriteAcp
 		}
-		if !service.IsAccessAllowed(req) {
-			log.Printf("Access denied location=%s, user=%s, groups=%s, accessType=%s",
-				location, username, groups, req.AccessType)
-			http.Error(w, "Access denied", http.StatusForbidden)
-			return
-		}
-		break
 	case "GET":
 		req.AccessType = ranger.Read
-		if len(query.Get("acl")) > 0 {
+		if _, ok := query["acl"]; ok {
 			req.AccessType = ranger.ReadAcp
 		}
-		if !service.IsAccessAllowed(req) {
-			log.Printf("Access denied location=%s, user=%s, groups=%s, accessType=%s",
-				location, username, groups, req.AccessType)
-			http.Error(w, "Access denied", http.StatusForbidden)
-			return
-		}
-		break
 	case "POST":
-		if len(query.Get("uploads")) > 0 {
+		if _, ok := query["uploads"]; ok {
 			log.Printf("skip notification for multipart INITIATE")
 		}
 		req.AccessType = ranger.Write
-		if !service.IsAccessAllowed(req) {
-			log.Printf("Access denied location=%s, user=%s, groups=%s, accessType=%s",
-				location, username, groups, req.AccessType)
-			http.Error(w, "Access denied", http.StatusForbidden)
-			return
-		}
-		break
 	default:
 		log.Printf("Access denied location=%s, user=%s, groups=%s, accessType=%s",
 			location, username, groups, "unkown")
@@ -189,23 +142,215 @@ func (p *Proxy) handle(w http.ResponseWriter, r *http.Request){
 		return
 	}
 
-	p.proxy.ServeHTTP(w, r)
+	// reuse the decision on an identical request with the tags it was made with, uploads
+	// with tags are evaluated against their own tags
+	var result *ranger.AccessResult
+	cached := false
+	cacheable := decisionCache != nil && r.Header.Get("x-amz-tagging") == ""
+	if cacheable {
+		var tags []ranger.Tag
+		if result, tags, cached = decisionCache.Get(service, req, query.Get("versionId")); cached {
+			req.Tags = tags
+		}
+	}
+
+	// add the context of the request, e.g. tags, for conditions and audit
+	if err := enrich(req, r); err != nil {
+		log.Printf("Cannot evaluate request location=%s, user=%s due to error %s\n", location, username, err)
+		if err == errInvalidRequest {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Cannot load the context of the request", http.StatusServiceUnavailable)
+		}
+		return
+	}
 
+	if !cached {
+		result = service.Evaluate(req)
+		if cacheable {
+			decisionCache.Add(service, req, query.Get("versionId"), result)
+		}
+	}
+	shadow := !result.IsAllowed && isAuditOnly(o)
+	defer func() {
+		record.log(req, result)
+		// denials that are not enforced are always audited
+		if auditor != nil && (result.IsAudited || shadow) {
+			event := newAuditEvent(service, req, result, record)
+			if shadow {
+				event.Reason = reasonAuditOnly
+			}
+			auditor.Log(event)
+		}
+	}()
 
+	if shadow {
+		log.Printf("Access would be denied in audit-only mode location=%s, user=%s, groups=%s, accessType=%s, policy=%d",
+			location, username, groups, req.AccessType, result.PolicyId)
+		auditOnlyDenials.Add(1)
+	} else if !result.IsAllowed {
+		log.Printf("Access denied location=%s, user=%s, groups=%s, accessType=%s, policy=%d, reason=%s",
+			location, username, groups, req.AccessType, result.PolicyId, result.Reason)
+		if policyHeader {
+			w.Header().Set(policyIdHeader, strconv.Itoa(result.PolicyId))
+		}
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	if r.Body != nil {
+		r.Body = &countingReader{ReadCloser: r.Body, count: &record.BytesIn}
+	}
+	p.proxy.ServeHTTP(w, r.WithContext(withRecord(r.Context(), record)))
+
+	// object or its tags may have changed
+	if tagCache != nil && len(k) > 0 && r.Method != "GET" && r.Method != "HEAD" {
+		tagCache.Invalidate(o, k, query.Get("versionId"))
+	}
+
+	// decisions may depend on the tags that changed, parts of uploads do not change them
+	if decisionCache != nil && r.Method != "GET" && r.Method != "HEAD" {
+		if len(k) == 0 {
+			decisionCache.InvalidateBucket(o)
+		} else if _, part := query["partNumber"]; !part {
+			decisionCache.InvalidateObject(location)
+		}
+	}
+
+}
+
+// newAuditEvent creates the Ranger audit event for an authorization decision and the
+// outcome of the request
+func newAuditEvent(service *ranger.Service, req *ranger.AccessRequest, result *ranger.AccessResult, record *requestRecord) *audit.Event {
+	event := &audit.Event{
+		RepoType: service.ServiceDef.Id,
+		Repo: service.ServiceName,
+		User: req.User,
+		EventTime: req.AccessTime.UTC(), // Ranger expects UTC
+		AccessType: req.AccessType,
+		Resource: req.Resource.Location,
+		ResourceType: ranger.ResourcePath,
+		Action: req.Action,
+		Result: audit.ResultDenied,
+		Agent: agentId,
+		PolicyId: result.PolicyId,
+		PolicyVersion: result.PolicyVersion,
+		SessionId: req.SessionId,
+		ClientType: req.ClientType,
+		ClientIp: req.ClientIpAddress,
+		RequestData: req.RequestData,
+		ClusterName: req.ClusterName,
+		EventDuration: record.Latency().Milliseconds(),
+		Status: record.Status,
+		UpstreamStatus: record.UpstreamStatus,
+		BytesIn: atomic.LoadInt64(&record.BytesIn),
+		BytesOut: record.BytesOut,
+		RequestId: record.RequestId,
+	}
+
+	if result.IsAllowed {
+		event.Result = audit.ResultAllowed
+	}
+
+	for _, tag := range req.Tags {
+		event.Tags = append(event.Tags, tag.Type)
+	}
+
+	return event
 }
 
+// RoundTrip forwards the request to RGW and completes the record of the request with
+// the upstream outcome
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
 	resp, err := http.DefaultTransport.RoundTrip(req)
 
+	if record := recordFromContext(req.Context()); record != nil {
+		record.UpstreamLatency = time.Since(start)
+		record.UpstreamError = err
+		if resp != nil {
+			record.UpstreamStatus = resp.StatusCode
+			record.RequestId = resp.Header.Get(rgwRequestIdHeader)
+		}
+	}
+
 	return resp, err
 }
 
+// locationOf returns the location of a bucket or an object of it
+func locationOf(bucket string, key string) string {
+	location := "/" + bucket
+	if len(key) > 0 {
+		location += "/" + key
+	}
+	return location
+}
+
+// newAccessRequest creates the request of a user for a bucket or an object of it with
+// the roles and attributes of the user and the owner of the bucket, which is looked up
+// if it is not given. It is used by the proxy and to explain decisions alike
+func newAccessRequest(ctx context.Context, username string, groups []string, bucket string, key string, owner string) *ranger.AccessRequest {
+	if owner == "" {
+		owner = getBucketOwner(ctx, bucket)
+	}
+
+	return &ranger.AccessRequest{
+		User: username,
+		UserGroups: groups,
+		UserRoles: policies.Roles().RolesFor(username, groups),
+		UserAttributes: policies.UserStore().Attributes(username),
+		Resource: ranger.AccessResource{
+			Owner: owner,
+			Location: locationOf(bucket, key),
+			Bucket: bucket,
+			Key: key,
+		},
+		AccessTime: time.Now(),
+	}
+}
+
+// getBucketOwner returns the owner of the bucket, empty if there is no bucket or it
+// cannot be loaded. The lookup is cancelled with ctx, failures are not cached
+func getBucketOwner(ctx context.Context, bucket string) string {
+	if len(bucket) == 0 {
+		return ""
+	}
+
+	item, found := ownerCache.Get(bucket)
+	if !found {
+		log.Printf("Cached owner not found for bucket=%s\n", bucket)
+		owner, err := radosClient.GetBucketOwner(ctx, bucket)
+		if err != nil {
+			return ""
+		}
+		ownerCache.SetDefault(bucket, owner)
+		return owner
+	}
+
+	return item.(string)
+}
+
+// isAuditOnly returns true if the policies are not enforced for the bucket
+func isAuditOnly(bucket string) bool {
+	if auditOnly {
+		return true
+	}
+
+	for _, pattern := range auditOnlyBuckets {
+		if ok, _ := path.Match(pattern, bucket); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
 func GetBucketObjectKey(s string) (string, string) {
 	pos := strings.Index(s, "?")
-	if pos > 0 {
-		s = s[0:pos-1]
+	if pos >= 0 {
+		s = s[0:pos]
 	}
-	split := strings.Split(s,"/")
+	split := strings.SplitN(s, "/", 3)
 
 	switch len(split) {
 	case 1:
@@ -215,6 +360,4 @@ func GetBucketObjectKey(s string) (string, string) {
 	default:
 		return split[1], split[2]
 	}
-
-	return "", ""
 }
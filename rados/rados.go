@@ -9,17 +9,34 @@ import (
 	"errors"
 )
 
+const defaultTimeout = 10 * time.Second
+
 type RadosClient struct {
 	EndPoint string
 	AccessKey string
 	SecretKey string
 	AdminPath string
+	Timeout int // seconds per admin request
+	CAFile string // ca bundle to verify an https admin endpoint with
+	InsecureSkipVerify bool
+
+	client *rgw.AdminAPI // non toml, shared by all admin requests
 }
 
-func (c *RadosClient) SyncUserAccessKeys() (map[string] string, error) {
+// Connect creates the admin client that is used for all admin requests, so the
+// connections of its pooled transport are reused. It must be called before the client
+// is used
+func (c *RadosClient) Connect() error {
+	timeout := time.Duration(c.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
 	cfg := &rgw.Config{
 		ClientConfig: rcl.ClientConfig{
-			ClientTimeout: rcl.Duration(time.Second * 10),
+			ClientTimeout: rcl.Duration(timeout),
+			CACertBundlePath: c.CAFile,
+			InsecureSkipVerify: c.InsecureSkipVerify,
 		},
 		ServerURL: c.EndPoint,
 		AdminPath: c.AdminPath,
@@ -30,15 +47,41 @@ func (c *RadosClient) SyncUserAccessKeys() (map[string] string, error) {
 
 	if err != nil {
 		log.Printf("Cannot connect to radosgw error=%s\n", err)
+		return err
+	}
+
+	c.client = client
+	return nil
+}
+
+func (c *RadosClient) adminAPI() (*rgw.AdminAPI, error) {
+	if c.client == nil {
+		return nil, errors.New("Not connected to radosgw")
+	}
+	return c.client, nil
+}
+
+// SyncUserAccessKeys returns the users of the access keys of all users, it stops when ctx
+// is done
+func (c *RadosClient) SyncUserAccessKeys(ctx context.Context) (map[string] string, error) {
+	client, err := c.adminAPI()
+	if err != nil {
 		return nil, err
 	}
 
 	key2user := make(map[string]string)
-	users, err := client.MListUsers(context.Background())
+	users, err := client.MListUsers(ctx)
+	if err != nil {
+		log.Printf("Cannot list users error=%s\n", err)
+		return nil, err
+	}
 
 	for _, username := range users {
-		user, err := client.MGetUser(context.Background(), username)
+		user, err := client.MGetUser(ctx, username)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			continue
 		}
 		for _, key := range user.Data.Keys {
@@ -49,30 +92,25 @@ func (c *RadosClient) SyncUserAccessKeys() (map[string] string, error) {
 	return key2user, nil
 }
 
-func (c *RadosClient) GetBucketOwner(bucket string)(string, error) {
-	cfg := &rgw.Config{
-		ClientConfig: rcl.ClientConfig{
-			ClientTimeout: rcl.Duration(time.Second * 10),
-		},
-		ServerURL: c.EndPoint,
-		AdminPath: c.AdminPath,
-		AccessKeyID: c.AccessKey,
-		SecretAccessKey: c.SecretKey,
-	}
-	client, err := rgw.NewAdminAPI(cfg)
-
+// GetBucketOwner returns the owner of the bucket, ctx is usually the context of the
+// request that needs the owner
+func (c *RadosClient) GetBucketOwner(ctx context.Context, bucket string)(string, error) {
+	client, err := c.adminAPI()
 	if err != nil {
-		log.Printf("Cannot connect to radosgw error=%s\n", err)
 		return "", err
 	}
 
-	stats, err := client.BucketStats(context.Background(), "", bucket)
+	stats, err := client.BucketStats(ctx, "", bucket)
 
 	if err != nil {
 		log.Printf("Cannot get bucket=%s, %s\n", bucket, err)
 		return "", err
 	}
 
+	if len(stats) == 0 {
+		return "", errors.New("No stats for bucket=" + bucket)
+	}
+
 	if len(stats) > 1 {
 		log.Printf("Too many stats (%d) returned for bucket=%s\n", len(stats), bucket)
 		return "", errors.New("Too many stats for bucket=" + bucket)
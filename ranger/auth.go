@@ -0,0 +1,108 @@
+package ranger
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+const defaultKrb5Conf = "/etc/krb5.conf"
+
+// Authenticator adds credentials to the requests to Ranger admin
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// BasicAuth authenticates with a username and password
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (a *BasicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+// KerberosAuth authenticates with SPNEGO using the credentials of a keytab
+type KerberosAuth struct {
+	SPN    string // service principal of Ranger admin, derived from the url if empty
+	client *client.Client
+}
+
+// NewKerberosAuth logs in as principal (user@REALM) with the keytab. An empty krb5Conf
+// uses /etc/krb5.conf. A failed login, e.g. because the KDC is down, is not an error, the
+// login is retried when the next request to Ranger is authenticated
+func NewKerberosAuth(principal string, keytabPath string, krb5Conf string) (*KerberosAuth, error) {
+	pos := strings.LastIndex(principal, "@")
+	if pos <= 0 || pos == len(principal)-1 {
+		return nil, errors.New("Principal must be of the form user@REALM: " + principal)
+	}
+
+	if krb5Conf == "" {
+		krb5Conf = defaultKrb5Conf
+	}
+
+	cfg, err := config.Load(krb5Conf)
+	if err != nil {
+		return nil, err
+	}
+
+	kt, err := keytab.Load(keytabPath)
+	if err != nil {
+		return nil, err
+	}
+
+	cl := client.NewWithKeytab(principal[:pos], principal[pos+1:], kt, cfg, client.DisablePAFXFAST(true))
+	if err = cl.Login(); err != nil {
+		log.Printf("Cannot log in as principal=%s due to error %s, retrying with the next request\n", principal, err)
+	}
+
+	return &KerberosAuth{client: cl}, nil
+}
+
+func (a *KerberosAuth) Authenticate(req *http.Request) error {
+	// logs in if the login failed before or the ticket expired
+	if err := a.client.AffirmLogin(); err != nil {
+		return err
+	}
+
+	return spnego.SetSPNEGOHeader(a.client, req, a.SPN)
+}
+
+// NewTLSConfig creates the tls configuration for connections to Ranger admin. The CA
+// bundle replaces the system roots, the client certificate is optional
+func NewTLSConfig(caFile string, certFile string, keyFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found in " + caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
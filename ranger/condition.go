@@ -0,0 +1,490 @@
+package ranger
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Evaluator class names of the condition evaluators in
+// https://github.com/apache/ranger/tree/master/agents-common/src/main/java/org/apache/ranger/plugin/conditionevaluator
+const (
+	IpMatcherEvaluator             = "org.apache.ranger.plugin.conditionevaluator.RangerIpMatcher"
+	TimeOfDayEvaluator             = "org.apache.ranger.plugin.conditionevaluator.RangerTimeOfDayMatcher"
+	ContextAttributeInEvaluator    = "org.apache.ranger.plugin.conditionevaluator.RangerContextAttributeValueInCondition"
+	ContextAttributeNotInEvaluator = "org.apache.ranger.plugin.conditionevaluator.RangerContextAttributeValueNotInCondition"
+	ScriptEvaluator                = "org.apache.ranger.plugin.conditionevaluator.RangerScriptConditionEvaluator"
+
+	ConditionIpAddressInRange    = "ipaddress-in-range"
+	ConditionIpRange             = "ip-range"
+	ConditionTimeOfDay           = "time-of-day"
+	ConditionDayOfWeek           = "day-of-week"
+	ConditionAccessedAfterExpiry = "accessed-after-expiry"
+	ConditionRequestHeader       = "request-header"
+	ConditionContextAttribute    = "context-attribute"
+	ConditionExpression          = "expression"
+
+	defaultExpiryAttribute = "expiry_date"
+)
+
+// ConditionEvaluator decides if a condition of a policy item is met by a request. def is
+// the declaration of the condition in the service definition, it is empty if the
+// service definition does not declare the condition
+type ConditionEvaluator interface {
+	IsMatched(c *Condition, def *PolicyCondition, r *AccessRequest) (bool, error)
+}
+
+// ConditionEvaluatorFunc allows the use of an ordinary function as ConditionEvaluator
+type ConditionEvaluatorFunc func(c *Condition, def *PolicyCondition, r *AccessRequest) (bool, error)
+
+func (f ConditionEvaluatorFunc) IsMatched(c *Condition, def *PolicyCondition, r *AccessRequest) (bool, error) {
+	return f(c, def, r)
+}
+
+var (
+	conditionEvaluatorsMu sync.RWMutex
+	conditionEvaluators   = make(map[string]ConditionEvaluator)
+)
+
+func init() {
+	ipRange := ConditionEvaluatorFunc(isInIpRange)
+	RegisterConditionEvaluator(ConditionIpAddressInRange, ipRange)
+	RegisterConditionEvaluator(ConditionIpRange, ipRange)
+	RegisterConditionEvaluator(IpMatcherEvaluator, ipRange)
+
+	RegisterConditionEvaluator(ConditionTimeOfDay, ConditionEvaluatorFunc(isInTimeOfDay))
+	RegisterConditionEvaluator(TimeOfDayEvaluator, ConditionEvaluatorFunc(isInTimeOfDay))
+	RegisterConditionEvaluator(ConditionDayOfWeek, ConditionEvaluatorFunc(isInDayOfWeek))
+
+	RegisterConditionEvaluator(ConditionAccessedAfterExpiry, ConditionEvaluatorFunc(isAccessedAfterExpiry))
+	RegisterConditionEvaluator(ConditionRequestHeader, ConditionEvaluatorFunc(isHeaderIn))
+
+	RegisterConditionEvaluator(ConditionContextAttribute, ConditionEvaluatorFunc(isAttributeIn))
+	RegisterConditionEvaluator(ContextAttributeInEvaluator, ConditionEvaluatorFunc(isAttributeIn))
+	RegisterConditionEvaluator(ContextAttributeNotInEvaluator, ConditionEvaluatorFunc(
+		func(c *Condition, def *PolicyCondition, r *AccessRequest) (bool, error) {
+			in, err := isAttributeIn(c, def, r)
+			return !in && err == nil, err
+		}))
+
+	RegisterConditionEvaluator(ConditionExpression, ConditionEvaluatorFunc(isExpressionTrue))
+	RegisterConditionEvaluator(ScriptEvaluator, ConditionEvaluatorFunc(isExpressionTrue))
+}
+
+// RegisterConditionEvaluator registers an evaluator for the conditions with the given name.
+// The name is either the name of the condition (e.g. ip-range) or the evaluator of the
+// condition in the service definition. An existing evaluator with the same name is replaced
+func RegisterConditionEvaluator(name string, evaluator ConditionEvaluator) {
+	conditionEvaluatorsMu.Lock()
+	defer conditionEvaluatorsMu.Unlock()
+
+	conditionEvaluators[name] = evaluator
+}
+
+// lookupConditionEvaluator finds the evaluator by condition name first and by the
+// evaluator declared in the service definition second
+func lookupConditionEvaluator(name string, def *PolicyCondition) ConditionEvaluator {
+	conditionEvaluatorsMu.RLock()
+	defer conditionEvaluatorsMu.RUnlock()
+
+	if evaluator, ok := conditionEvaluators[name]; ok {
+		return evaluator
+	}
+
+	if def != nil {
+		if evaluator, ok := conditionEvaluators[def.Evaluator]; ok {
+			return evaluator
+		}
+	}
+
+	return nil
+}
+
+// isMatch checks if the condition is met by the request
+func (c *Condition) isMatch(def *PolicyCondition, r *AccessRequest) (bool, error) {
+	evaluator := lookupConditionEvaluator(c.Type, def)
+	if evaluator == nil {
+		return false, errors.New("Unknown condition:" + c.Type)
+	}
+
+	if def == nil {
+		def = &PolicyCondition{Name: c.Type}
+	}
+
+	return evaluator.IsMatched(c, def, r)
+}
+
+// prepare compiles the values of an expression condition, so they are not parsed
+// again for every request
+func (c *Condition) prepare(def *PolicyCondition) {
+	if !isExpressionCondition(c.Type, def) {
+		return
+	}
+	c.expressions, c.compileErr = compileExpressions(c.Values)
+}
+
+func isExpressionCondition(name string, def *PolicyCondition) bool {
+	if name == ConditionExpression || name == ScriptEvaluator {
+		return true
+	}
+	return def != nil && (def.Evaluator == ConditionExpression || def.Evaluator == ScriptEvaluator)
+}
+
+func compileExpressions(values []string) ([]expression, error) {
+	expressions := make([]expression, 0, len(values))
+	for _, value := range values {
+		expr, err := compileExpression(value)
+		if err != nil {
+			return nil, err
+		}
+		expressions = append(expressions, expr)
+	}
+	return expressions, nil
+}
+
+// ipMatcher matches an ip address against a cidr (IPv4 or IPv6), a single address
+// or an IPv4 wildcard pattern such as 10.1.*
+type ipMatcher struct {
+	subnet  *net.IPNet
+	ip      net.IP
+	pattern string
+}
+
+func newIpMatcher(value string) (*ipMatcher, error) {
+	value = strings.TrimSpace(value)
+
+	if strings.Contains(value, "/") {
+		_, subnet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		return &ipMatcher{subnet: subnet}, nil
+	}
+
+	if strings.ContainsAny(value, MATCH_ANY+MATCH_ONE) {
+		return &ipMatcher{pattern: value}, nil
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, errors.New("Invalid ip address " + value)
+	}
+
+	return &ipMatcher{ip: ip}, nil
+}
+
+func (m *ipMatcher) contains(ip net.IP) bool {
+	switch {
+	case m.subnet != nil:
+		return m.subnet.Contains(ip)
+	case m.ip != nil:
+		return m.ip.Equal(ip)
+	}
+
+	return wildcardMatch(m.pattern, ip.String())
+}
+
+// isInIpRange checks that all IPs (remote, client, forward addresses) supplied in the
+// chain from the client are within the ranges specified
+func isInIpRange(c *Condition, def *PolicyCondition, r *AccessRequest) (bool, error) {
+	var matchers []*ipMatcher
+	for _, value := range c.Values {
+		m, err := newIpMatcher(value)
+		if err != nil {
+			log.Printf("Invalid ip range=%s: %s\n", value, err)
+			continue
+		}
+		matchers = append(matchers, m)
+	}
+
+	inRange := func(address string) (bool, error) {
+		ip := net.ParseIP(strings.TrimSpace(address))
+		if ip == nil {
+			return false, errors.New("Invalid address " + address)
+		}
+		for _, m := range matchers {
+			if m.contains(ip) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	addresses := append([]string{r.ClientIpAddress, r.RemoteIpAddress}, r.ForwardedAdresses...)
+	for _, address := range addresses {
+		ok, err := inRange(address)
+		if !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+var timeOfDayRegex = regexp.MustCompile(`^\s*(\d{1,2})(?::(\d{2}))?\s*([AaPp][Mm])?\s*-\s*(\d{1,2})(?::(\d{2}))?\s*([AaPp][Mm])?\s*$`)
+
+// parses hours, minutes and an optional am/pm marker to minutes since midnight
+func minutesOfDay(hours string, minutes string, marker string) (int, error) {
+	h, err := strconv.Atoi(hours)
+	if err != nil {
+		return 0, err
+	}
+
+	m := 0
+	if minutes != "" {
+		if m, err = strconv.Atoi(minutes); err != nil {
+			return 0, err
+		}
+	}
+
+	switch strings.ToLower(marker) {
+	case "am":
+		if h == 12 {
+			h = 0
+		}
+	case "pm":
+		if h < 12 {
+			h += 12
+		}
+	}
+
+	// 24:00 is allowed as the end of the day
+	if h > 24 || m > 59 || (h == 24 && m > 0) {
+		return 0, fmt.Errorf("Invalid time %s:%s%s", hours, minutes, marker)
+	}
+
+	return h*60 + m, nil
+}
+
+// isInTimeOfDay checks if the access time is within any of the windows such as
+// 9am-5pm or 22:00-06:00. Windows that end before they start cross midnight
+func isInTimeOfDay(c *Condition, def *PolicyCondition, r *AccessRequest) (bool, error) {
+	now := r.AccessTime.Hour()*60 + r.AccessTime.Minute()
+
+	for _, value := range c.Values {
+		parts := timeOfDayRegex.FindStringSubmatch(value)
+		if parts == nil {
+			log.Printf("Invalid time of day=%s\n", value)
+			continue
+		}
+
+		start, err := minutesOfDay(parts[1], parts[2], parts[3])
+		if err != nil {
+			log.Printf("Invalid time of day=%s: %s\n", value, err)
+			continue
+		}
+		end, err := minutesOfDay(parts[4], parts[5], parts[6])
+		if err != nil {
+			log.Printf("Invalid time of day=%s: %s\n", value, err)
+			continue
+		}
+
+		if start <= end && now >= start && now <= end {
+			return true, nil
+		}
+		if start > end && (now >= start || now <= end) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseWeekday(value string) (time.Weekday, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if len(value) < 3 {
+		return 0, false
+	}
+	day, ok := weekdays[value[:3]]
+	return day, ok
+}
+
+// isInDayOfWeek checks if the access time falls on any of the days or ranges of days
+// such as mon-fri or sat
+func isInDayOfWeek(c *Condition, def *PolicyCondition, r *AccessRequest) (bool, error) {
+	today := r.AccessTime.Weekday()
+
+	for _, value := range c.Values {
+		bounds := strings.SplitN(value, "-", 2)
+		start, ok := parseWeekday(bounds[0])
+		end := start
+		if ok && len(bounds) == 2 {
+			end, ok = parseWeekday(bounds[1])
+		}
+		if !ok {
+			log.Printf("Invalid day of week=%s\n", value)
+			continue
+		}
+
+		if start <= end && today >= start && today <= end {
+			return true, nil
+		}
+		if start > end && (today >= start || today <= end) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+var dateLayouts = []string{time.RFC3339, "2006/01/02 15:04:05", "2006/01/02", "2006-01-02 15:04:05", "2006-01-02"}
+
+func parseDate(value string, loc *time.Location) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.New("Invalid date " + value)
+}
+
+// isAccessedAfterExpiry checks if the access time is past the expiry date in the tag
+// attribute (expiry_date by default). A condition value of no or false inverts the check
+func isAccessedAfterExpiry(c *Condition, def *PolicyCondition, r *AccessRequest) (bool, error) {
+	attribute := def.EvaluatorOptions.AttributeName
+	if attribute == "" {
+		attribute = defaultExpiryAttribute
+	}
+
+	// an object without an expiry date never expires
+	expired := false
+	if value, ok := r.TagAttributes[attribute]; ok {
+		expiry, err := parseDate(value, r.AccessTime.Location())
+		if err != nil {
+			return false, err
+		}
+		expired = r.AccessTime.After(expiry)
+	}
+
+	for _, v := range c.Values {
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "no", "false":
+			return !expired, nil
+		}
+	}
+
+	return expired, nil
+}
+
+// isHeaderIn checks if the request header named by the attributeName option matches any
+// of the values, values may contain wildcards
+func isHeaderIn(c *Condition, def *PolicyCondition, r *AccessRequest) (bool, error) {
+	name := def.EvaluatorOptions.AttributeName
+	if name == "" {
+		return false, errors.New("No attributeName configured for condition " + def.Name)
+	}
+
+	for _, header := range r.Headers[http.CanonicalHeaderKey(name)] {
+		for _, value := range c.Values {
+			if wildcardMatch(value, header) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// isAttributeIn checks if the request context attribute named by the attributeName
+// option has any of the values
+func isAttributeIn(c *Condition, def *PolicyCondition, r *AccessRequest) (bool, error) {
+	name := def.EvaluatorOptions.AttributeName
+	if name == "" {
+		return false, errors.New("No attributeName configured for condition " + def.Name)
+	}
+
+	attribute, ok := r.Context[name]
+	if !ok {
+		return false, nil
+	}
+
+	return contains(c.Values, fmt.Sprint(attribute)), nil
+}
+
+// isExpressionTrue evaluates the expressions in the condition values, any expression
+// that is true meets the condition
+func isExpressionTrue(c *Condition, def *PolicyCondition, r *AccessRequest) (bool, error) {
+	expressions, err := c.expressions, c.compileErr
+	if expressions == nil && err == nil {
+		// not prepared
+		expressions, err = compileExpressions(c.Values)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, expr := range expressions {
+		result, err := expr.eval(r)
+		if err != nil {
+			return false, err
+		}
+
+		if truthy(result) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// DecisionInputs tells what decisions of the policies depend on besides the user, its
+// groups, roles and attributes, the resource with its tags and the access type
+type DecisionInputs struct {
+	ClientIp bool // an ip condition
+	Context  bool // any other condition, e.g. on the time or the request context
+}
+
+// isIpCondition checks if a condition only depends on the client ip address
+func isIpCondition(c *Condition, def *PolicyCondition) bool {
+	switch c.Type {
+	case ConditionIpAddressInRange, ConditionIpRange, IpMatcherEvaluator:
+		return true
+	}
+
+	return def != nil && def.Evaluator == IpMatcherEvaluator
+}
+
+// DecisionInputs returns what the decisions of the policies, of the zones and the tag
+// service, depend on. Validity schedules are not included, see NextValidityChange
+func (s *Service) DecisionInputs() DecisionInputs {
+	var inputs DecisionInputs
+	s.visitPolicies(func(zone string, p *Policy) {
+		for _, items := range [][]PolicyItem{p.PolicyItems, p.DenyPolicyItems, p.AllowExceptions, p.DenyExceptions} {
+			for _, item := range items {
+				for i := range item.Conditions {
+					if isIpCondition(&item.Conditions[i], s.conditionDefs[item.Conditions[i].Type]) {
+						inputs.ClientIp = true
+					} else {
+						inputs.Context = true
+					}
+				}
+			}
+		}
+	})
+
+	if s.TagPolicies != nil {
+		tagInputs := s.TagPolicies.DecisionInputs()
+		inputs.ClientIp = inputs.ClientIp || tagInputs.ClientIp
+		inputs.Context = inputs.Context || tagInputs.Context
+	}
+
+	return inputs
+}
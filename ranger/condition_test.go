@@ -0,0 +1,145 @@
+package ranger
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConditions(t *testing.T) {
+	r := &AccessRequest{
+		User:              "bob",
+		UserGroups:        []string{"admins"},
+		AccessTime:        time.Date(2024, 5, 6, 10, 30, 0, 0, time.UTC), // a Monday
+		ClientIpAddress:   "10.1.2.3",
+		RemoteIpAddress:   "10.1.2.4",
+		ForwardedAdresses: []string{"10.1.9.9"},
+		Headers:           http.Header{"User-Agent": {"aws-cli/2.0.0"}},
+		TagAttributes:     map[string]string{"expiry_date": "2024/05/01", "until": "2024-12-31"},
+		Context:           map[string]interface{}{"clientType": "aws-cli", "count": 1},
+	}
+	header := &PolicyCondition{Name: ConditionRequestHeader, EvaluatorOptions: EvaluatorOptions{AttributeName: "user-agent"}}
+	attribute := &PolicyCondition{Name: "client", Evaluator: ContextAttributeInEvaluator, EvaluatorOptions: EvaluatorOptions{AttributeName: "clientType"}}
+	notAttribute := &PolicyCondition{Name: "client", Evaluator: ContextAttributeNotInEvaluator, EvaluatorOptions: EvaluatorOptions{AttributeName: "clientType"}}
+	expiry := &PolicyCondition{Name: ConditionAccessedAfterExpiry, EvaluatorOptions: EvaluatorOptions{AttributeName: "until"}}
+
+	cases := []struct {
+		condition Condition
+		def       *PolicyCondition
+		want      bool
+		err       bool
+	}{
+		{Condition{Type: ConditionIpRange, Values: []string{"10.1.0.0/16"}}, nil, true, false},
+		{Condition{Type: ConditionIpAddressInRange, Values: []string{"10.1.*"}}, nil, true, false},
+		{Condition{Type: ConditionIpRange, Values: []string{"10.1.2.3"}}, nil, false, false},
+		{Condition{Type: ConditionIpRange, Values: []string{"10.1.2.0/24"}}, nil, false, false},
+		{Condition{Type: ConditionIpRange, Values: []string{"10.2.0.0/16", "::1/128"}}, nil, false, false},
+		{Condition{Type: ConditionIpRange, Values: []string{"bogus", "10.0.0.0/8"}}, nil, true, false},
+		{Condition{Type: "ip", Values: []string{"10.0.0.0/8"}}, &PolicyCondition{Name: "ip", Evaluator: IpMatcherEvaluator}, true, false},
+
+		{Condition{Type: ConditionTimeOfDay, Values: []string{"9am-5pm"}}, nil, true, false},
+		{Condition{Type: ConditionTimeOfDay, Values: []string{"9:00 AM - 10:29 AM"}}, nil, false, false},
+		{Condition{Type: ConditionTimeOfDay, Values: []string{"22:00-06:00"}}, nil, false, false},
+		{Condition{Type: ConditionTimeOfDay, Values: []string{"10:00-02:00"}}, nil, true, false},
+		{Condition{Type: ConditionTimeOfDay, Values: []string{"noon", "10:30-10:30"}}, nil, true, false},
+		{Condition{Type: ConditionTimeOfDay, Values: []string{"10:00-24:00"}}, nil, true, false},
+		{Condition{Type: ConditionTimeOfDay, Values: []string{"10:00-24:30"}}, nil, false, false},
+
+		{Condition{Type: ConditionDayOfWeek, Values: []string{"mon-fri"}}, nil, true, false},
+		{Condition{Type: ConditionDayOfWeek, Values: []string{"sat-sun"}}, nil, false, false},
+		{Condition{Type: ConditionDayOfWeek, Values: []string{"Friday-Monday"}}, nil, true, false},
+		{Condition{Type: ConditionDayOfWeek, Values: []string{"tue", "wed"}}, nil, false, false},
+
+		{Condition{Type: ConditionAccessedAfterExpiry, Values: []string{"yes"}}, nil, true, false},
+		{Condition{Type: ConditionAccessedAfterExpiry, Values: []string{"no"}}, nil, false, false},
+		{Condition{Type: ConditionAccessedAfterExpiry, Values: []string{"yes"}}, expiry, false, false},
+		{Condition{Type: ConditionAccessedAfterExpiry, Values: []string{"yes"}}, &PolicyCondition{EvaluatorOptions: EvaluatorOptions{AttributeName: "missing"}}, false, false},
+		{Condition{Type: ConditionAccessedAfterExpiry, Values: []string{"no"}}, &PolicyCondition{EvaluatorOptions: EvaluatorOptions{AttributeName: "missing"}}, true, false},
+
+		{Condition{Type: ConditionRequestHeader, Values: []string{"aws-cli/*"}}, header, true, false},
+		{Condition{Type: ConditionRequestHeader, Values: []string{"s3cmd/*"}}, header, false, false},
+		{Condition{Type: ConditionRequestHeader, Values: []string{"*"}}, nil, false, true},
+
+		{Condition{Type: "client", Values: []string{"aws-cli", "s3cmd"}}, attribute, true, false},
+		{Condition{Type: "client", Values: []string{"s3cmd"}}, attribute, false, false},
+		{Condition{Type: "client", Values: []string{"s3cmd"}}, notAttribute, true, false},
+		{Condition{Type: ConditionContextAttribute, Values: []string{"1"}}, &PolicyCondition{EvaluatorOptions: EvaluatorOptions{AttributeName: "count"}}, true, false},
+
+		{Condition{Type: ConditionExpression, Values: []string{`"admins" in groups && hour >= 8`}}, nil, true, false},
+		{Condition{Type: ConditionExpression, Values: []string{`user == "alice"`, `weekday == "mon"`}}, nil, true, false},
+		{Condition{Type: ConditionExpression, Values: []string{`user ==`}}, nil, false, true},
+
+		{Condition{Type: "unknown", Values: nil}, nil, false, true},
+	}
+
+	for _, c := range cases {
+		got, err := c.condition.isMatch(c.def, r)
+		if got != c.want || (err != nil) != c.err {
+			t.Errorf("%s %v: got %v error %v, want %v", c.condition.Type, c.condition.Values, got, err, c.want)
+		}
+	}
+}
+
+func TestIpRangeInvalidAddress(t *testing.T) {
+	r := &AccessRequest{ClientIpAddress: "10.1.2.3", RemoteIpAddress: "unknown"}
+	c := &Condition{Type: ConditionIpRange, Values: []string{"10.0.0.0/8"}}
+
+	if ok, err := c.isMatch(nil, r); ok || err == nil {
+		t.Errorf("got %v error %v, want no match and an error", ok, err)
+	}
+}
+
+func TestDecisionInputs(t *testing.T) {
+	item := func(conditions ...Condition) []PolicyItem {
+		return []PolicyItem{{Users: []string{"bob"}, Conditions: conditions}}
+	}
+
+	cases := []struct {
+		name    string
+		service *Service
+		want    DecisionInputs
+	}{
+		{"none", &Service{Policies: []Policy{{PolicyItems: item()}}}, DecisionInputs{}},
+		{"ip", &Service{Policies: []Policy{{PolicyItems: item(Condition{Type: ConditionIpRange, Values: []string{"10.0.0.0/8"}})}}}, DecisionInputs{ClientIp: true}},
+		{"time", &Service{Policies: []Policy{{DenyPolicyItems: item(Condition{Type: ConditionTimeOfDay, Values: []string{"9am-5pm"}})}}}, DecisionInputs{Context: true}},
+		{"zone", &Service{SecurityZones: map[string]*SecurityZone{"z": {Policies: []Policy{{AllowExceptions: item(Condition{Type: ConditionExpression, Values: []string{"true"}})}}}}}, DecisionInputs{Context: true}},
+		{"tags", &Service{TagPolicies: &Service{Policies: []Policy{{PolicyItems: item(Condition{Type: ConditionIpRange, Values: []string{"10.0.0.0/8"}})}}}}, DecisionInputs{ClientIp: true}},
+	}
+
+	for _, c := range cases {
+		if got := c.service.DecisionInputs(); got != c.want {
+			t.Errorf("%s: DecisionInputs() = %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPrepareExpressions(t *testing.T) {
+	service := &Service{
+		ServiceDef: ServiceDefinition{PolicyConditions: []PolicyCondition{{Name: "script", Evaluator: ScriptEvaluator}}},
+		Policies: []Policy{{PolicyItems: []PolicyItem{{Conditions: []Condition{
+			{Type: ConditionExpression, Values: []string{`user == "bob"`, "hour > 8"}},
+			{Type: "script", Values: []string{"user =="}},
+			{Type: ConditionIpRange, Values: []string{"10.0.0.0/8"}},
+		}}}}},
+	}
+	service.prepare()
+
+	conditions := service.Policies[0].PolicyItems[0].Conditions
+	if len(conditions[0].expressions) != 2 || conditions[0].compileErr != nil {
+		t.Errorf("expression: compiled %d expressions error %v, want 2", len(conditions[0].expressions), conditions[0].compileErr)
+	}
+	if conditions[1].compileErr == nil {
+		t.Errorf("script: no compile error for an invalid expression")
+	}
+	if conditions[2].expressions != nil || conditions[2].compileErr != nil {
+		t.Errorf("ip-range: compiled as an expression")
+	}
+
+	r := &AccessRequest{User: "bob", AccessTime: time.Date(2024, 5, 6, 10, 30, 0, 0, time.UTC)}
+	if ok, err := conditions[0].isMatch(nil, r); !ok || err != nil {
+		t.Errorf("expression: got %v error %v, want a match", ok, err)
+	}
+	if ok, err := conditions[1].isMatch(&service.ServiceDef.PolicyConditions[0], r); ok || err == nil {
+		t.Errorf("script: got %v error %v, want no match and an error", ok, err)
+	}
+}
@@ -0,0 +1,147 @@
+package ranger
+
+import (
+	"sort"
+	"time"
+)
+
+// Kinds of policy changes
+const (
+	PolicyAdded    = "added"
+	PolicyRemoved  = "removed"
+	PolicyModified = "modified"
+)
+
+// PolicyChange is a policy that was added, removed or modified between two versions of
+// the policies of a service. The version of a policy is 0 if it did not exist
+type PolicyChange struct {
+	Service    string
+	Zone       string `json:",omitempty"`
+	Change     string
+	PolicyId   int
+	PolicyName string
+	OldVersion int
+	NewVersion int
+}
+
+// PolicyDiff are the policy changes of an activation of new policies
+type PolicyDiff struct {
+	Time        time.Time
+	Service     string
+	FromVersion int
+	ToVersion   int
+	// versions of the policies of the tag service, -1 without a tag service
+	FromTagVersion int
+	ToTagVersion   int
+	Changes        []PolicyChange
+}
+
+// Diff returns the changes of the policies, of the zones and the tag service, from old
+// to new. Policies are compared by id and version
+func Diff(old *Service, new *Service) *PolicyDiff {
+	diff := &PolicyDiff{
+		Time:           time.Now(),
+		Service:        new.ServiceName,
+		FromVersion:    -1,
+		ToVersion:      new.PolicyVersion,
+		FromTagVersion: -1,
+		ToTagVersion:   tagVersion(new),
+	}
+	if old != nil {
+		diff.FromVersion = old.PolicyVersion
+		diff.FromTagVersion = tagVersion(old)
+	}
+	diff.Changes = diffService(old, new)
+
+	return diff
+}
+
+func tagVersion(s *Service) int {
+	if s.TagPolicies == nil {
+		return -1
+	}
+	return s.TagPolicies.PolicyVersion
+}
+
+func diffService(old *Service, new *Service) []PolicyChange {
+	before := make(map[int]zonedPolicy)
+	if old != nil {
+		old.visitPolicies(func(zone string, p *Policy) { before[p.Id] = zonedPolicy{zone, p} })
+	}
+
+	serviceName := new.ServiceName
+	var changes []PolicyChange
+	new.visitPolicies(func(zone string, p *Policy) {
+		change := PolicyChange{
+			Service:    serviceName,
+			Zone:       zone,
+			PolicyId:   p.Id,
+			PolicyName: p.Name,
+			NewVersion: p.Version,
+		}
+		if previous, ok := before[p.Id]; !ok {
+			change.Change = PolicyAdded
+		} else {
+			delete(before, p.Id)
+			if previous.policy.Version == p.Version {
+				return
+			}
+			change.Change = PolicyModified
+			change.OldVersion = previous.policy.Version
+		}
+		changes = append(changes, change)
+	})
+
+	for _, p := range before {
+		changes = append(changes, PolicyChange{
+			Service:    old.ServiceName,
+			Zone:       p.zone,
+			Change:     PolicyRemoved,
+			PolicyId:   p.policy.Id,
+			PolicyName: p.policy.Name,
+			OldVersion: p.policy.Version,
+		})
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].PolicyId < changes[j].PolicyId
+	})
+
+	var oldTags *Service
+	if old != nil {
+		oldTags = old.TagPolicies
+	}
+	if new.TagPolicies != nil {
+		changes = append(changes, diffService(oldTags, new.TagPolicies)...)
+	} else if oldTags != nil {
+		oldTags.visitPolicies(func(zone string, p *Policy) {
+			changes = append(changes, PolicyChange{
+				Service:    oldTags.ServiceName,
+				Zone:       zone,
+				Change:     PolicyRemoved,
+				PolicyId:   p.Id,
+				PolicyName: p.Name,
+				OldVersion: p.Version,
+			})
+		})
+	}
+
+	return changes
+}
+
+type zonedPolicy struct {
+	zone   string
+	policy *Policy
+}
+
+// visitPolicies calls visit for the policies of the service and its zones, the zone is
+// empty for policies that are not in a zone
+func (s *Service) visitPolicies(visit func(zone string, p *Policy)) {
+	for i := range s.Policies {
+		visit("", &s.Policies[i])
+	}
+	for name, zone := range s.SecurityZones {
+		for i := range zone.Policies {
+			visit(name, &zone.Policies[i])
+		}
+	}
+}
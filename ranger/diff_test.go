@@ -0,0 +1,108 @@
+package ranger
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	v := func(id int, version int) Policy {
+		return Policy{Id: id, Name: fmt.Sprint("p", id), Version: version}
+	}
+	zone := func(policies ...Policy) map[string]*SecurityZone {
+		return map[string]*SecurityZone{"z": {Policies: policies}}
+	}
+	tags := func(policies ...Policy) *Service {
+		return &Service{ServiceName: "tags", PolicyVersion: 3, Policies: policies}
+	}
+
+	cases := []struct {
+		name string
+		old  *Service
+		new  *Service
+		want []string
+	}{
+		{"unchanged", &Service{Policies: []Policy{v(1, 1)}}, &Service{Policies: []Policy{v(1, 1)}}, nil},
+		{"first", nil, &Service{Policies: []Policy{v(2, 1), v(1, 1)}}, []string{"s3 added 1 0->1", "s3 added 2 0->1"}},
+		{"modified", &Service{Policies: []Policy{v(1, 1)}}, &Service{Policies: []Policy{v(1, 2)}}, []string{"s3 modified 1 1->2"}},
+		{"removed", &Service{Policies: []Policy{v(1, 1), v(2, 1)}}, &Service{Policies: []Policy{v(2, 1)}}, []string{"s3 removed 1 1->0"}},
+		{"zone added", &Service{}, &Service{SecurityZones: zone(v(3, 1))}, []string{"s3/z added 3 0->1"}},
+		{"tags modified", &Service{TagPolicies: tags(v(9, 1))}, &Service{TagPolicies: tags(v(9, 2))}, []string{"tags modified 9 1->2"}},
+		{"tags added", &Service{}, &Service{TagPolicies: tags(v(9, 1))}, []string{"tags added 9 0->1"}},
+		{"tags removed", &Service{TagPolicies: tags(v(9, 1))}, &Service{}, []string{"tags removed 9 1->0"}},
+		{"mixed", &Service{Policies: []Policy{v(1, 1), v(2, 1)}, TagPolicies: tags(v(9, 1))},
+			&Service{Policies: []Policy{v(3, 1), v(1, 2)}, TagPolicies: tags(v(8, 1))},
+			[]string{"s3 modified 1 1->2", "s3 removed 2 1->0", "s3 added 3 0->1", "tags added 8 0->1", "tags removed 9 1->0"}},
+	}
+
+	for _, c := range cases {
+		for _, s := range []*Service{c.old, c.new} {
+			if s != nil {
+				s.ServiceName = "s3"
+			}
+		}
+
+		var got []string
+		for _, change := range Diff(c.old, c.new).Changes {
+			service := change.Service
+			if change.Zone != "" {
+				service += "/" + change.Zone
+			}
+			got = append(got, fmt.Sprintf("%s %s %d %d->%d", service, change.Change, change.PolicyId, change.OldVersion, change.NewVersion))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%s: changes %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestChangeHistory(t *testing.T) {
+	versions := []string{
+		`{"serviceName":"s3","policyVersion":1,"policies":[{"id":1,"version":1}]}`,
+		`{"serviceName":"s3","policyVersion":2,"policies":[{"id":1,"version":2}]}`,
+		`{"serviceName":"s3","policyVersion":2,"policies":[{"id":1,"version":2}],"tagPolicies":{"serviceName":"tags","policyVersion":5,"policies":[{"id":9,"version":1}]}}`,
+		`{"serviceName":"s3","policyVersion":3,"policies":[{"id":1,"version":2},{"id":2,"version":1}],"tagPolicies":{"serviceName":"tags","policyVersion":5,"policies":[{"id":9,"version":1}]}}`,
+	}
+
+	download := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, versions[download])
+	}))
+	defer srv.Close()
+
+	refresher := NewPolicyRefresher(NewPolicyClient("s3", []string{srv.URL}), 0, 0)
+	refresher.History = 2
+
+	cases := []struct {
+		to      []int // policy versions of the history, latest first
+		toTags  []int
+		changes int // changes of the latest diff
+	}{
+		{nil, nil, 0},
+		{[]int{2}, []int{-1}, 1},
+		{[]int{2, 2}, []int{5, -1}, 1}, // tag-only update
+		{[]int{3, 2}, []int{5, 5}, 1},
+	}
+
+	for i, c := range cases {
+		download = i
+		if err := refresher.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+
+		var to, toTags []int
+		history := refresher.Changes()
+		for _, diff := range history {
+			to = append(to, diff.ToVersion)
+			toTags = append(toTags, diff.ToTagVersion)
+		}
+		if fmt.Sprint(to) != fmt.Sprint(c.to) || fmt.Sprint(toTags) != fmt.Sprint(c.toTags) {
+			t.Errorf("download %d: history to versions %v tags %v, want %v %v", i, to, toTags, c.to, c.toTags)
+		}
+		if len(history) > 0 && len(history[0].Changes) != c.changes {
+			t.Errorf("download %d: changes %v, want %d", i, history[0].Changes, c.changes)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package ranger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Change types of a policy delta, see RangerPolicyDelta in Apache Ranger
+const (
+	ChangeTypePolicyCreate = 0
+	ChangeTypePolicyUpdate = 1
+	ChangeTypePolicyDelete = 2
+
+	serviceTypeS3 = "s3"
+)
+
+// ErrNotModified is returned by a download if the policies did not change since the
+// last known version
+var ErrNotModified = errors.New("Policies not modified")
+
+// errFullDownload signals deltas that cannot be applied to the current policies
+var errFullDownload = errors.New("Policy deltas cannot be applied, full download required")
+
+type PolicyDelta struct {
+	Id          int64
+	ChangeType  int
+	ServiceType string
+	ZoneName    string
+	Policy      *Policy
+}
+
+// PolicyClient downloads the policies of a service from Ranger admin
+type PolicyClient struct {
+	ServiceName string
+	BaseUrls    []string // Ranger admin urls, the next one is tried if one fails
+	PluginId    string
+	ClusterName string
+	HTTPClient  *http.Client
+	Secure      bool          // use the secure download endpoint
+	Auth        Authenticator // optional credentials for Ranger admin
+
+	lastActivationTime int64
+	current            int // index of the last working url
+}
+
+func NewPolicyClient(serviceName string, baseUrls []string) *PolicyClient {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	return &PolicyClient{
+		ServiceName: serviceName,
+		BaseUrls:    baseUrls,
+		PluginId:    serviceTypeS3 + "@" + hostname + "-" + serviceName,
+		HTTPClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Download loads the policies that changed since the version of current, which can be
+// nil to load all policies. Policy deltas are applied to a copy of current. It returns
+// ErrNotModified if Ranger reports that the policies did not change
+func (c *PolicyClient) Download(current *Service) (*Service, error) {
+	service, err := c.download(current)
+	if err == errFullDownload {
+		log.Printf("Downloading all policies for service=%s\n", c.ServiceName)
+		service, err = c.download(nil)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.lastActivationTime = time.Now().UnixNano() / int64(time.Millisecond)
+	return service, nil
+}
+
+// download loads the policies that changed since the version of current
+func (c *PolicyClient) download(current *Service) (*Service, error) {
+	endpoint := policyEndpoint
+	if c.Secure {
+		endpoint = policySecureEndpoint
+	}
+
+	version := -1
+	if current != nil {
+		version = current.PolicyVersion
+	}
+
+	params := c.params()
+	params.Add(lastKnownVersion, strconv.Itoa(version))
+	params.Add(supportsPolicyDeltas, "true")
+
+	data, err := c.fetch(endpoint+c.ServiceName, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var service Service
+	if err = json.Unmarshal(data, &service); err != nil {
+		return nil, err
+	}
+
+	if len(service.PolicyDeltas) > 0 {
+		if current == nil || current.ServiceDef.Version != service.ServiceDef.Version {
+			return nil, errFullDownload
+		}
+		return current.applyDeltas(&service)
+	}
+
+	if current != nil && service.PolicyVersion == current.PolicyVersion && len(service.Policies) == 0 {
+		return nil, ErrNotModified
+	}
+
+	service.prepare()
+	return &service, nil
+}
+
+// params returns the query parameters that identify the plugin to Ranger
+func (c *PolicyClient) params() url.Values {
+	params := url.Values{}
+	params.Add(pluginId, c.PluginId)
+	params.Add(lastActivationTime, strconv.FormatInt(c.lastActivationTime, 10))
+	if c.ClusterName != "" {
+		params.Add(clusterName, c.ClusterName)
+	}
+
+	return params
+}
+
+// fetch gets the endpoint trying the urls starting at the last working one until one of
+// them responds. It returns ErrNotModified if Ranger reports that nothing changed
+func (c *PolicyClient) fetch(endpoint string, params url.Values) ([]byte, error) {
+	if len(c.BaseUrls) == 0 {
+		return nil, errors.New("No Ranger urls configured for service=" + c.ServiceName)
+	}
+
+	var err error
+	for i := 0; i < len(c.BaseUrls); i++ {
+		index := (c.current + i) % len(c.BaseUrls)
+
+		var data []byte
+		data, err = c.fetchFrom(c.BaseUrls[index], endpoint, params)
+		if err == nil || err == ErrNotModified {
+			if index != c.current {
+				log.Printf("Switched to Ranger url=%s\n", c.BaseUrls[index])
+				c.current = index
+			}
+			return data, err
+		}
+
+		log.Printf("Cannot download from url=%s%s error=%s\n", c.BaseUrls[index], endpoint, err)
+	}
+
+	return nil, err
+}
+
+func (c *PolicyClient) fetchFrom(baseUrl string, endpoint string, params url.Values) ([]byte, error) {
+	req, err := http.NewRequest("GET", baseUrl+endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.RawQuery = params.Encode()
+
+	if c.Auth != nil {
+		if err = c.Auth.Authenticate(req); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return data, nil
+	case http.StatusNotModified:
+		return nil, ErrNotModified
+	default:
+		if len(data) > 256 {
+			data = data[:256]
+		}
+		return nil, fmt.Errorf("Ranger returned status=%d for service=%s: %s", resp.StatusCode, c.ServiceName, data)
+	}
+}
+
+// applyDeltas returns a copy of the service with the policy deltas of the update applied
+func (s *Service) applyDeltas(update *Service) (*Service, error) {
+	service := *s
+	service.PolicyVersion = update.PolicyVersion
+	service.PolicyUpdateTime = update.PolicyUpdateTime
+	service.PolicyDeltas = nil
+	service.Policies = append([]Policy(nil), s.Policies...)
+
+	if s.TagPolicies != nil {
+		tagPolicies := *s.TagPolicies
+		tagPolicies.Policies = append([]Policy(nil), s.TagPolicies.Policies...)
+		service.TagPolicies = &tagPolicies
+	}
+
+	for _, delta := range update.PolicyDeltas {
+		target := &service
+		if delta.ServiceType != "" && delta.ServiceType != s.ServiceDef.Name {
+			if service.TagPolicies == nil || delta.ServiceType != service.TagPolicies.ServiceDef.Name {
+				return nil, errFullDownload
+			}
+			target = service.TagPolicies
+		}
+
+		if delta.Policy == nil {
+			return nil, errFullDownload
+		}
+		if delta.ZoneName != "" || delta.Policy.ZoneName != "" {
+			// zoned policies are replaced with their zone by a full download
+			return nil, errFullDownload
+		}
+
+		switch delta.ChangeType {
+		case ChangeTypePolicyCreate, ChangeTypePolicyUpdate:
+			target.Policies = append(target.removePolicy(delta.Policy.Id), *delta.Policy)
+		case ChangeTypePolicyDelete:
+			target.Policies = target.removePolicy(delta.Policy.Id)
+		default:
+			log.Printf("Unsupported policy delta change type=%d\n", delta.ChangeType)
+			return nil, errFullDownload
+		}
+	}
+
+	service.prepare()
+	return &service, nil
+}
+
+// removePolicy returns a copy of the policies without the policy with the given id
+func (s *Service) removePolicy(id int) []Policy {
+	policies := make([]Policy, 0, len(s.Policies)+1)
+	for _, p := range s.Policies {
+		if p.Id != id {
+			policies = append(policies, p)
+		}
+	}
+
+	return policies
+}
@@ -0,0 +1,189 @@
+package ranger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func policy(id int, location string) Policy {
+	return Policy{
+		Id:          id,
+		Version:     1,
+		IsEnabled:   true,
+		Resources:   map[string]ResourceData{"path": {Values: []string{location}}},
+		PolicyItems: []PolicyItem{{Users: []string{"bob"}, Accesses: []Access{{Type: "read", IsAllowed: true}}}},
+	}
+}
+
+func policyIds(policies []Policy) []int {
+	ids := make([]int, 0, len(policies))
+	for _, p := range policies {
+		ids = append(ids, p.Id)
+	}
+	return ids
+}
+
+func TestApplyDeltas(t *testing.T) {
+	current := &Service{
+		ServiceName:   "s3",
+		PolicyVersion: 1,
+		ServiceDef:    ServiceDefinition{Name: "s3"},
+		Policies:      []Policy{policy(1, "/a"), policy(2, "/b")},
+		TagPolicies:   &Service{ServiceName: "tags", ServiceDef: ServiceDefinition{Name: "tag"}, Policies: []Policy{policy(9, "*")}},
+	}
+	updated := policy(2, "/c")
+
+	cases := []struct {
+		name   string
+		deltas []PolicyDelta
+		want   []int
+		tags   []int
+		full   bool
+	}{
+		{"create", []PolicyDelta{{ChangeType: ChangeTypePolicyCreate, Policy: &Policy{Id: 3}}}, []int{1, 2, 3}, []int{9}, false},
+		{"update", []PolicyDelta{{ChangeType: ChangeTypePolicyUpdate, Policy: &updated}}, []int{1, 2}, []int{9}, false},
+		{"delete", []PolicyDelta{{ChangeType: ChangeTypePolicyDelete, Policy: &Policy{Id: 1}}}, []int{2}, []int{9}, false},
+		{"tag service", []PolicyDelta{{ChangeType: ChangeTypePolicyDelete, ServiceType: "tag", Policy: &Policy{Id: 9}}}, []int{1, 2}, []int{}, false},
+		{"unknown service", []PolicyDelta{{ChangeType: ChangeTypePolicyDelete, ServiceType: "hive", Policy: &Policy{Id: 9}}}, nil, nil, true},
+		{"zone", []PolicyDelta{{ChangeType: ChangeTypePolicyCreate, ZoneName: "z", Policy: &Policy{Id: 4}}}, nil, nil, true},
+		{"no policy", []PolicyDelta{{ChangeType: ChangeTypePolicyDelete}}, nil, nil, true},
+		{"unknown change", []PolicyDelta{{ChangeType: 7, Policy: &Policy{Id: 1}}}, nil, nil, true},
+	}
+
+	for _, c := range cases {
+		service, err := current.applyDeltas(&Service{PolicyVersion: 2, PolicyDeltas: c.deltas})
+		if c.full {
+			if err != errFullDownload {
+				t.Errorf("%s: error %v, want a full download", c.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error %s", c.name, err)
+			continue
+		}
+		if service.PolicyVersion != 2 || fmt.Sprint(policyIds(service.Policies)) != fmt.Sprint(c.want) ||
+			fmt.Sprint(policyIds(service.TagPolicies.Policies)) != fmt.Sprint(c.tags) {
+			t.Errorf("%s: version %d policies %v tags %v, want %v %v", c.name, service.PolicyVersion,
+				policyIds(service.Policies), policyIds(service.TagPolicies.Policies), c.want, c.tags)
+		}
+	}
+
+	if len(current.Policies) != 2 || len(current.TagPolicies.Policies) != 1 || current.PolicyVersion != 1 {
+		t.Errorf("deltas changed the current policies %v", policyIds(current.Policies))
+	}
+}
+
+func TestDownload(t *testing.T) {
+	full := `{"serviceName":"s3","policyVersion":1,"serviceDef":{"version":1},"policies":[` +
+		`{"id":1,"isEnabled":true,"resources":{"path":{"values":["/a"]}},"policyItems":[{"users":["bob"],"accesses":[{"type":"read","isAllowed":true}]}]}]}`
+	delta := `{"serviceName":"s3","policyVersion":2,"serviceDef":{"version":1},"policyDeltas":[` +
+		`{"changeType":0,"policy":{"id":2,"isEnabled":true,"resources":{"path":{"values":["/b"]}},"policyItems":[{"users":["bob"],"accesses":[{"type":"read","isAllowed":true}]}]}},` +
+		`{"changeType":2,"policy":{"id":1}}]}`
+	otherDef := `{"serviceName":"s3","policyVersion":3,"serviceDef":{"version":2},"policyDeltas":[{"changeType":2,"policy":{"id":2}}]}`
+	unchanged := `{"serviceName":"s3","policyVersion":1}`
+
+	cases := []struct {
+		name      string
+		responses []string // a body or a status code
+		current   *Service
+		version   string // lastKnownVersion of the first request
+		want      []int
+		err       error
+	}{
+		{"full", []string{full}, nil, "-1", []int{1}, nil},
+		{"not modified", []string{"304"}, &Service{PolicyVersion: 1}, "1", nil, ErrNotModified},
+		{"same version", []string{unchanged}, &Service{PolicyVersion: 1}, "1", nil, ErrNotModified},
+		{"deltas", []string{delta}, mustPrepare(full), "1", []int{2}, nil},
+		{"deltas of another definition", []string{otherDef, full}, mustPrepare(full), "1", []int{1}, nil},
+		{"deltas without policies", []string{delta, full}, nil, "-1", []int{1}, nil},
+	}
+
+	for _, c := range cases {
+		var versions []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			versions = append(versions, r.URL.Query().Get(lastKnownVersion))
+			response := c.responses[len(versions)-1]
+			if response == "304" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			fmt.Fprint(w, response)
+		}))
+
+		service, err := NewPolicyClient("s3", []string{srv.URL}).Download(c.current)
+		srv.Close()
+
+		if err != c.err {
+			t.Errorf("%s: error %v, want %v", c.name, err, c.err)
+			continue
+		}
+		if len(versions) == 0 || versions[0] != c.version {
+			t.Errorf("%s: lastKnownVersion %v, want %s", c.name, versions, c.version)
+		}
+		if err == nil && fmt.Sprint(policyIds(service.Policies)) != fmt.Sprint(c.want) {
+			t.Errorf("%s: policies %v, want %v", c.name, policyIds(service.Policies), c.want)
+		}
+	}
+}
+
+func mustPrepare(data string) *Service {
+	var service Service
+	if err := json.Unmarshal([]byte(data), &service); err != nil {
+		panic(err)
+	}
+	service.prepare()
+	return &service
+}
+
+func TestRefreshKeepsPolicies(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		fmt.Fprint(w, `{"serviceName":"s3","policyVersion":1,"policies":[{"id":1}]}`)
+	}))
+	defer srv.Close()
+
+	refresher := NewPolicyRefresher(NewPolicyClient("s3", []string{srv.URL}), 0, 0)
+
+	var i int
+	for i, status = range []int{http.StatusOK, http.StatusNotModified, http.StatusInternalServerError, http.StatusOK} {
+		err := refresher.Refresh()
+		if (err != nil) != (status == http.StatusInternalServerError) {
+			t.Errorf("refresh %d with status %d: error %v", i, status, err)
+		}
+		if refresher.Service() == nil || refresher.Service().PolicyVersion != 1 {
+			t.Errorf("refresh %d with status %d: lost the policies", i, status)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	refresher := NewPolicyRefresher(nil, 10*time.Second, time.Minute)
+	refresher.Jitter = 0
+
+	cases := []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 20 * time.Second},
+		{2, 40 * time.Second},
+		{3, time.Minute},
+		{10, time.Minute},
+	}
+
+	for _, c := range cases {
+		refresher.failures = c.failures
+		if got := refresher.next(); got != c.want {
+			t.Errorf("next() after %d failures = %s, want %s", c.failures, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package ranger
+
+import (
+	"fmt"
+)
+
+// Names of the item lists of a policy as they are named in Ranger
+const (
+	itemsAllow           = "policyItems"
+	itemsDeny            = "denyPolicyItems"
+	itemsAllowExceptions = "allowExceptions"
+)
+
+// itemsMatch is the outcome of matching the items of a policy against a request
+type itemsMatch struct {
+	item          int    // first item that matches, -1 if none
+	exception     int    // exception that overrules the item, -1 if none
+	conditionItem int    // first item that only failed on a condition, -1 if none
+	condition     string // condition that was not met by conditionItem
+}
+
+func (m itemsMatch) isMatch() bool {
+	return m.item >= 0 && m.exception < 0
+}
+
+func itemName(items string, index int) string {
+	return fmt.Sprintf("%s[%d]", items, index)
+}
+
+// nearMiss records why an item of the policy that almost matched the request did not
+// decide it. Only the first one is kept, it explains a denial if no policy decided
+func (ar *AccessResult) nearMiss(p *Policy, m itemsMatch, items string, exceptions string) {
+	if ar.Exception != "" || ar.Condition != "" {
+		return
+	}
+
+	policy := fmt.Sprintf("policy id=%d name=%s version=%d", p.Id, p.Name, p.Version)
+	switch {
+	case m.item >= 0 && m.exception >= 0:
+		ar.Exception = itemName(exceptions, m.exception)
+		ar.Reason = fmt.Sprintf("%s of %s is overruled by %s", itemName(items, m.item), policy, ar.Exception)
+	case m.conditionItem >= 0:
+		ar.Condition = m.condition
+		ar.Reason = fmt.Sprintf("condition %s of %s of %s is not met", m.condition, itemName(items, m.conditionItem), policy)
+	}
+}
+
+// decide takes over the outcome of the evaluation of the policies of a tag
+func (ar *AccessResult) decide(other *AccessResult, tag string) {
+	ar.IsAllowed = other.IsAllowed
+	ar.PolicyId = other.PolicyId
+	ar.PolicyName = other.PolicyName
+	ar.PolicyVersion = other.PolicyVersion
+	ar.PolicyPriority = other.PolicyPriority
+	ar.Item = other.Item
+	ar.Exception = other.Exception
+	ar.Condition = other.Condition
+	ar.Reason = other.Reason
+	ar.Tag = tag
+}
+
+// explain sets the reason of the outcome
+func (ar *AccessResult) explain() {
+	if ar.PolicyId < 0 {
+		if ar.Reason == "" {
+			ar.Reason = "no policy allows access"
+		} else {
+			ar.Reason = "no policy allows access, " + ar.Reason
+		}
+		return
+	}
+
+	ar.Exception = ""
+	ar.Condition = ""
+
+	policy := fmt.Sprintf("policy id=%d name=%s version=%d", ar.PolicyId, ar.PolicyName, ar.PolicyVersion)
+	if ar.PolicyPriority == PolicyPriorityOverride {
+		policy = "override " + policy
+	}
+	if ar.Tag != "" {
+		policy += " for tag " + ar.Tag
+	}
+
+	if ar.IsAllowed {
+		ar.Reason = fmt.Sprintf("allowed by %s of %s", ar.Item, policy)
+	} else {
+		ar.Reason = fmt.Sprintf("denied by %s of %s", ar.Item, policy)
+	}
+}
@@ -0,0 +1,386 @@
+package ranger
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A small expression language for policy conditions, for example:
+//
+//	"admins" in groups && hour >= 8 && hour < 18
+//	header.User-Agent =~ "^aws-cli/" || tag.classification != "restricted"
+//
+// Operands are string literals, numbers, true/false and the request attributes below.
+// Operators are ||, &&, !, ==, !=, <, <=, >, >=, =~ (regular expression) and in.
+//
+//	user, owner, groups, accessType, action, location, bucket, key, clientIp, remoteIp,
+//	clientType, clusterName, sessionId, hour, minute, weekday, date,
+//	user.<attribute>, tag.<attribute>, ctx.<attribute>, header.<name>
+
+type expression interface {
+	eval(r *AccessRequest) (interface{}, error)
+}
+
+type literalExpr struct {
+	value interface{}
+}
+
+type identExpr struct {
+	name string
+}
+
+type notExpr struct {
+	expr expression
+}
+
+type binaryExpr struct {
+	op    string
+	left  expression
+	right expression
+	regex *regexp.Regexp // precompiled for =~ with a literal pattern
+}
+
+// compileExpression parses an expression
+func compileExpression(source string) (expression, error) {
+	p := &exprParser{tokens: tokenize(source)}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("Unexpected token=%s in expression=%s", p.tokens[p.pos], source)
+	}
+
+	return expr, nil
+}
+
+func tokenize(source string) []string {
+	var tokens []string
+
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(source) && source[j] != c {
+				if source[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j > len(source) {
+				j = len(source)
+			}
+			tokens = append(tokens, source[i:j])
+			i = j + 1
+		case strings.ContainsRune("()", rune(c)):
+			tokens = append(tokens, string(c))
+			i++
+		case strings.ContainsRune("!=<>&|~", rune(c)):
+			j := i + 1
+			if j < len(source) && strings.ContainsRune("=&|~", rune(source[j])) {
+				j++
+			}
+			tokens = append(tokens, source[i:j])
+			i = j
+		default:
+			j := i
+			for j < len(source) && (unicode.IsLetter(rune(source[j])) || unicode.IsDigit(rune(source[j])) ||
+				strings.ContainsRune("_.-:/", rune(source[j]))) {
+				j++
+			}
+			if j == i {
+				j++
+			}
+			tokens = append(tokens, source[i:j])
+			i = j
+		}
+	}
+
+	return tokens
+}
+
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *exprParser) parseOr() (expression, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.peek() == "||" {
+		p.next()
+		var right expression
+		if right, err = p.parseAnd(); err == nil {
+			left = &binaryExpr{op: "||", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (expression, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.peek() == "&&" {
+		p.next()
+		var right expression
+		if right, err = p.parseUnary(); err == nil {
+			left = &binaryExpr{op: "&&", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseUnary() (expression, error) {
+	if p.peek() == "!" {
+		p.next()
+		expr, err := p.parseUnary()
+		return &notExpr{expr: expr}, err
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (expression, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	switch op := p.peek(); op {
+	case "==", "!=", "<", "<=", ">", ">=", "=~", "in":
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		expr := &binaryExpr{op: op, left: left, right: right}
+		if lit, ok := right.(*literalExpr); ok && op == "=~" {
+			if expr.regex, err = regexp.Compile(fmt.Sprint(lit.value)); err != nil {
+				return nil, err
+			}
+		}
+		return expr, nil
+	}
+
+	return left, nil
+}
+
+func (p *exprParser) parseOperand() (expression, error) {
+	token := p.next()
+
+	switch {
+	case token == "":
+		return nil, errors.New("Unexpected end of expression")
+	case token == "(":
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("Missing ) in expression")
+		}
+		return expr, nil
+	case token[0] == '"' || token[0] == '\'':
+		value, err := strconv.Unquote(`"` + strings.Replace(token[1:], `"`, `\"`, -1) + `"`)
+		if err != nil {
+			value = token[1:]
+		}
+		return &literalExpr{value: value}, nil
+	case token == "true" || token == "false":
+		return &literalExpr{value: token == "true"}, nil
+	}
+
+	if f, err := strconv.ParseFloat(token, 64); err == nil {
+		return &literalExpr{value: f}, nil
+	}
+
+	if !unicode.IsLetter(rune(token[0])) {
+		return nil, fmt.Errorf("Unexpected token=%s in expression", token)
+	}
+
+	return &identExpr{name: token}, nil
+}
+
+func (e *literalExpr) eval(r *AccessRequest) (interface{}, error) {
+	return e.value, nil
+}
+
+func (e *identExpr) eval(r *AccessRequest) (interface{}, error) {
+	switch e.name {
+	case "user":
+		return r.User, nil
+	case "owner":
+		return r.Resource.Owner, nil
+	case "groups":
+		return r.UserGroups, nil
+	case "accessType":
+		return r.AccessType, nil
+	case "action":
+		return r.Action, nil
+	case "location":
+		return r.Resource.Location, nil
+	case "bucket":
+		return r.Resource.Bucket, nil
+	case "key":
+		return r.Resource.Key, nil
+	case "clientIp":
+		return r.ClientIpAddress, nil
+	case "remoteIp":
+		return r.RemoteIpAddress, nil
+	case "clientType":
+		return r.ClientType, nil
+	case "clusterName":
+		return r.ClusterName, nil
+	case "sessionId":
+		return r.SessionId, nil
+	case "hour":
+		return float64(r.AccessTime.Hour()), nil
+	case "minute":
+		return float64(r.AccessTime.Minute()), nil
+	case "weekday":
+		return strings.ToLower(r.AccessTime.Weekday().String()[:3]), nil
+	case "date":
+		return r.AccessTime.Format("2006-01-02"), nil
+	}
+
+	pos := strings.Index(e.name, ".")
+	if pos < 0 {
+		return nil, errors.New("Unknown attribute " + e.name)
+	}
+
+	prefix, name := e.name[:pos], e.name[pos+1:]
+	switch prefix {
+	case "user":
+		return r.UserAttributes[name], nil
+	case "tag":
+		return r.TagAttributes[name], nil
+	case "ctx":
+		if value, ok := r.Context[name]; ok {
+			return fmt.Sprint(value), nil
+		}
+		return "", nil
+	case "header":
+		return r.Headers.Get(http.CanonicalHeaderKey(name)), nil
+	}
+
+	return nil, errors.New("Unknown attribute " + e.name)
+}
+
+func (e *notExpr) eval(r *AccessRequest) (interface{}, error) {
+	value, err := e.expr.eval(r)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(value), nil
+}
+
+func (e *binaryExpr) eval(r *AccessRequest) (interface{}, error) {
+	left, err := e.left.eval(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// short circuit the boolean operators
+	switch e.op {
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+	}
+
+	right, err := e.right.eval(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "&&", "||":
+		return truthy(right), nil
+	case "in":
+		if list, ok := right.([]string); ok {
+			return contains(list, fmt.Sprint(left)), nil
+		}
+		return strings.Contains(fmt.Sprint(right), fmt.Sprint(left)), nil
+	case "=~":
+		regex := e.regex
+		if regex == nil {
+			if regex, err = regexp.Compile(fmt.Sprint(right)); err != nil {
+				return nil, err
+			}
+		}
+		return regex.MatchString(fmt.Sprint(left)), nil
+	}
+
+	cmp := compareValues(left, right)
+	switch e.op {
+	case "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+
+	return nil, errors.New("Unknown operator " + e.op)
+}
+
+// compareValues compares numerically if both values are numbers and as strings otherwise
+func compareValues(left interface{}, right interface{}) int {
+	l, lerr := strconv.ParseFloat(fmt.Sprint(left), 64)
+	r, rerr := strconv.ParseFloat(fmt.Sprint(right), 64)
+
+	if lerr == nil && rerr == nil {
+		switch {
+		case l < r:
+			return -1
+		case l > r:
+			return 1
+		}
+		return 0
+	}
+
+	return strings.Compare(fmt.Sprint(left), fmt.Sprint(right))
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	case []string:
+		return len(v) > 0
+	}
+
+	return value != nil
+}
@@ -0,0 +1,111 @@
+package ranger
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestExpression(t *testing.T) {
+	r := &AccessRequest{
+		User:            "bob",
+		UserGroups:      []string{"admins", "users"},
+		AccessType:      "read",
+		ClientIpAddress: "10.1.2.3",
+		AccessTime:      time.Date(2024, 5, 6, 10, 30, 0, 0, time.UTC), // a Monday
+		Resource:        AccessResource{Bucket: "data", Key: "a/b.txt", Location: "/data/a/b.txt", Owner: "alice"},
+		UserAttributes:  map[string]string{"dept": "fin"},
+		TagAttributes:   map[string]string{"level": "3"},
+		Headers:         http.Header{"User-Agent": {"aws-cli/2.0.0"}},
+		Context:         map[string]interface{}{"clientType": "aws-cli"},
+	}
+
+	cases := []struct {
+		source string
+		want   bool
+	}{
+		{`user == "bob"`, true},
+		{`user == 'bob'`, true},
+		{`user != "bob"`, false},
+		{`owner == "alice" && bucket == "data"`, true},
+		{`"admins" in groups`, true},
+		{`"guests" in groups`, false},
+		{`"a/" in key`, true},
+		{`location =~ "^/data/.*\.txt$"`, true},
+		{`header.User-Agent =~ "^aws-cli/"`, true},
+		{`hour >= 8 && hour < 17`, true},
+		{`minute == 30`, true},
+		{`weekday == "mon"`, true},
+		{`date == "2024-05-06"`, true},
+		{`tag.level > 2`, true},
+		{`tag.level > 10`, false},
+		{`user.dept == "fin"`, true},
+		{`ctx.clientType == "aws-cli"`, true},
+		{`ctx.missing == ""`, true},
+		{`!(user == "bob") || accessType == "write"`, false},
+		{`!tag.missing`, true},
+		{`clientIp == "10.1.2.3" || unknown`, true},
+	}
+
+	for _, c := range cases {
+		expr, err := compileExpression(c.source)
+		if err != nil {
+			t.Errorf("compileExpression(%s) error %s", c.source, err)
+			continue
+		}
+		result, err := expr.eval(r)
+		if err != nil {
+			t.Errorf("eval(%s) error %s", c.source, err)
+			continue
+		}
+		if got := truthy(result); got != c.want {
+			t.Errorf("eval(%s) = %v, want %v", c.source, result, c.want)
+		}
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	r := &AccessRequest{}
+
+	cases := []string{
+		`user ==`,
+		`user == "bob" "alice"`,
+		`(user == "bob"`,
+		`user =~ "["`,
+		`unknown == "x"`,
+		`foo.bar == "x"`,
+	}
+
+	for _, source := range cases {
+		expr, err := compileExpression(source)
+		if err == nil {
+			_, err = expr.eval(r)
+		}
+		if err == nil {
+			t.Errorf("%s: expected an error", source)
+		}
+	}
+}
+
+func TestTruthy(t *testing.T) {
+	cases := []struct {
+		value interface{}
+		want  bool
+	}{
+		{true, true},
+		{false, false},
+		{"x", true},
+		{"", false},
+		{1.0, true},
+		{0.0, false},
+		{[]string{"a"}, true},
+		{[]string{}, false},
+		{nil, false},
+	}
+
+	for _, c := range cases {
+		if got := truthy(c.value); got != c.want {
+			t.Errorf("truthy(%v) = %v, want %v", c.value, got, c.want)
+		}
+	}
+}
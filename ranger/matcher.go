@@ -0,0 +1,368 @@
+package ranger
+
+import (
+	"log"
+	"sort"
+	"strings"
+)
+
+// Resource matching follows the semantics of the matchers in
+// https://github.com/apache/ranger/tree/master/agents-common/src/main/java/org/apache/ranger/plugin/resourcematcher
+const (
+	PathResourceMatcher    = "org.apache.ranger.plugin.resourcematcher.RangerPathResourceMatcher"
+	DefaultResourceMatcher = "org.apache.ranger.plugin.resourcematcher.RangerDefaultResourceMatcher"
+
+	ResourceBucket = "bucket"
+	ResourcePath   = "path"
+
+	defaultPathSeparator = '/'
+	defaultTokenStart    = '{'
+	defaultTokenEnd      = '}'
+	defaultTokenEscape   = '\\'
+
+	// macros that can be used in resource values
+	TokenUser          = "USER"
+	TokenOwner         = "OWNER"
+	TokenUserAttribute = "USER."
+	TokenTagAttribute  = "TAG."
+)
+
+// matcherValue is a policy resource value, values with macros are resolved per request
+type matcherValue struct {
+	value     string
+	hasTokens bool
+}
+
+// resourceMatcher matches the value of a single resource in a request against
+// the values of that resource in a policy
+type resourceMatcher struct {
+	values      []matcherValue
+	isExcludes  bool
+	isRecursive bool
+	isPath      bool
+	ignoreCase  bool
+	wildcard    bool
+	separator   byte
+	matchAny    bool
+
+	replaceTokens bool
+	tokenStart    byte
+	tokenEnd      byte
+	tokenEscape   byte
+	tokenPrefix   string
+}
+
+// levelMatcher binds a resource matcher to the resource definition it was created for
+type levelMatcher struct {
+	def     *ServiceResource
+	matcher *resourceMatcher
+}
+
+// policyResourceMatcher matches all resources of a policy against a request. Every
+// resource of the policy needs to match (resources are AND-ed)
+type policyResourceMatcher struct {
+	levels   []levelMatcher
+	children []*ServiceResource // resources below the policy in the hierarchy
+}
+
+// parses a boolean matcher option, Ranger defaults both wildCard and ignoreCase to true
+func optionEnabled(value string, def bool) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return def
+}
+
+// parses a single character matcher option
+func optionChar(value string, def byte) byte {
+	if len(value) == 1 {
+		return value[0]
+	}
+	return def
+}
+
+func newResourceMatcher(def *ServiceResource, data ResourceData) *resourceMatcher {
+	m := &resourceMatcher{
+		isExcludes:  data.IsExcludes,
+		isRecursive: data.IsRecursive,
+		isPath:      def.Matcher == PathResourceMatcher,
+		ignoreCase:  optionEnabled(def.MatcherOptions.IgnoreCase, true),
+		wildcard:    optionEnabled(def.MatcherOptions.Wildcard, true),
+		separator:   optionChar(def.MatcherOptions.PathSeparatorChar, defaultPathSeparator),
+
+		replaceTokens: optionEnabled(def.MatcherOptions.ReplaceTokens, true),
+		tokenStart:    optionChar(def.MatcherOptions.TokenDelimiterStart, defaultTokenStart),
+		tokenEnd:      optionChar(def.MatcherOptions.TokenDelimiterEnd, defaultTokenEnd),
+		tokenEscape:   optionChar(def.MatcherOptions.TokenDelimiterEscape, defaultTokenEscape),
+		tokenPrefix:   def.MatcherOptions.TokenDelimiterPrefix,
+	}
+
+	for _, value := range data.Values {
+		value = strings.TrimSpace(value)
+		if value == MATCH_ANY {
+			m.matchAny = true
+		}
+
+		hasTokens := m.replaceTokens && strings.IndexByte(value, m.tokenStart) >= 0
+		if m.ignoreCase && !hasTokens {
+			value = strings.ToLower(value)
+		}
+		m.values = append(m.values, matcherValue{value: value, hasTokens: hasTokens})
+	}
+
+	if len(m.values) == 0 {
+		m.matchAny = true
+	}
+
+	return m
+}
+
+// isMatchAny returns true if the matcher accepts any value including an absent one
+func (m *resourceMatcher) isMatchAny() bool {
+	return m.matchAny && !m.isExcludes
+}
+
+func (m *resourceMatcher) isMatch(resource string, r *AccessRequest) bool {
+	if m.ignoreCase {
+		resource = strings.ToLower(resource)
+	}
+
+	matched := m.matchAny
+	for i := 0; !matched && i < len(m.values); i++ {
+		value := m.values[i].value
+		if m.values[i].hasTokens {
+			var ok bool
+			if value, ok = m.resolveTokens(value, r); !ok {
+				continue
+			}
+			if m.ignoreCase {
+				value = strings.ToLower(value)
+			}
+		}
+		matched = m.matchValue(value, resource)
+	}
+
+	if m.isExcludes {
+		return !matched
+	}
+
+	return matched
+}
+
+func (m *resourceMatcher) hasWildcard(value string) bool {
+	return m.wildcard && strings.ContainsAny(value, MATCH_ANY+MATCH_ONE)
+}
+
+func (m *resourceMatcher) matchValue(value string, resource string) bool {
+	wildcard := m.hasWildcard(value)
+
+	if !m.isPath || !m.isRecursive {
+		if wildcard {
+			return wildcardMatch(value, resource)
+		}
+		return value == resource
+	}
+
+	if wildcard {
+		// a recursive wildcard matches if the resource or any of its parents match
+		for i := 1; i < len(resource); i++ {
+			if resource[i] == m.separator && wildcardMatch(value, resource[:i]) {
+				return true
+			}
+		}
+		return wildcardMatch(value, resource)
+	}
+
+	if value == "" {
+		// e.g. a macro that resolved to nothing, it is not a parent of anything
+		return resource == ""
+	}
+
+	if !strings.HasPrefix(resource, value) {
+		return false
+	}
+
+	return len(resource) == len(value) ||
+		value[len(value)-1] == m.separator ||
+		resource[len(value)] == m.separator
+}
+
+// resolveTokens replaces the macros in value (e.g. {USER}) by their value for the request.
+// It returns false if a macro cannot be resolved, in which case the value cannot match
+func (m *resourceMatcher) resolveTokens(value string, r *AccessRequest) (string, bool) {
+	var sb strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+
+		if c == m.tokenEscape && i+1 < len(value) {
+			i++
+			sb.WriteByte(value[i])
+			continue
+		}
+
+		if c != m.tokenStart {
+			sb.WriteByte(c)
+			continue
+		}
+
+		end := strings.IndexByte(value[i+1:], m.tokenEnd)
+		if end < 0 {
+			sb.WriteString(value[i:])
+			break
+		}
+
+		token := value[i+1 : i+1+end]
+		if !strings.HasPrefix(token, m.tokenPrefix) {
+			sb.WriteString(value[i : i+end+2])
+			i += end + 1
+			continue
+		}
+
+		resolved, ok := r.tokenValue(token[len(m.tokenPrefix):])
+		if !ok {
+			log.Printf("Cannot resolve token=%s for user=%s\n", token, r.User)
+			return "", false
+		}
+
+		sb.WriteString(resolved)
+		i += end + 1
+	}
+
+	return sb.String(), true
+}
+
+// tokenValue returns the value of a resource macro for the request
+func (r *AccessRequest) tokenValue(token string) (string, bool) {
+	switch {
+	case token == TokenUser:
+		return r.User, r.User != ""
+	case token == TokenOwner:
+		return r.Resource.Owner, r.Resource.Owner != ""
+	case strings.HasPrefix(token, TokenUserAttribute):
+		value, ok := r.UserAttributes[token[len(TokenUserAttribute):]]
+		return value, ok
+	case strings.HasPrefix(token, TokenTagAttribute):
+		value, ok := r.TagAttributes[token[len(TokenTagAttribute):]]
+		return value, ok
+	}
+
+	return "", false
+}
+
+// wildcardMatch matches str against pattern in which '*' matches any sequence of
+// characters (including the path separator) and '?' matches a single character
+func wildcardMatch(pattern string, str string) bool {
+	p, s := 0, 0
+	star, mark := -1, 0
+
+	for s < len(str) {
+		if p < len(pattern) && (pattern[p] == '?' || pattern[p] == str[s]) {
+			p++
+			s++
+		} else if p < len(pattern) && pattern[p] == '*' {
+			star = p
+			mark = s
+			p++
+		} else if star != -1 {
+			p = star + 1
+			mark++
+			s = mark
+		} else {
+			return false
+		}
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+// value returns the value of the resource in the request for the given resource
+// definition. A resource that has a parent (e.g. path in bucket) only receives the
+// part of the location below its parent
+func (r *AccessResource) value(def *ServiceResource) string {
+	if v, ok := r.Elements[def.Name]; ok {
+		return v
+	}
+
+	if def.Name == ResourceBucket {
+		return r.Bucket
+	}
+
+	if def.Parent != "" {
+		return r.Key
+	}
+
+	return r.Location
+}
+
+func newPolicyResourceMatcher(serviceDef *ServiceDefinition, resources map[string]ResourceData) *policyResourceMatcher {
+	defs := make(map[string]*ServiceResource)
+	for i := range serviceDef.Resources {
+		defs[serviceDef.Resources[i].Name] = &serviceDef.Resources[i]
+	}
+
+	m := &policyResourceMatcher{}
+	for name, data := range resources {
+		def, ok := defs[name]
+		if !ok {
+			// not declared by the service definition, match against the location
+			def = &ServiceResource{Name: name, Matcher: PathResourceMatcher}
+		}
+		m.levels = append(m.levels, levelMatcher{def: def, matcher: newResourceMatcher(def, data)})
+	}
+
+	sort.SliceStable(m.levels, func(i, j int) bool { return m.levels[i].def.Level < m.levels[j].def.Level })
+
+	// resources that are descendants of the policy resources must be absent from
+	// the request, otherwise the request is for something below the policy
+	for name, def := range defs {
+		if _, ok := resources[name]; ok {
+			continue
+		}
+		for parent := defs[def.Parent]; parent != nil; parent = defs[parent.Parent] {
+			if _, ok := resources[parent.Name]; ok {
+				m.children = append(m.children, def)
+				break
+			}
+			if parent.Parent == parent.Name {
+				break
+			}
+		}
+	}
+
+	return m
+}
+
+func (m *policyResourceMatcher) isMatch(r *AccessRequest) bool {
+	if len(m.levels) == 0 {
+		return false
+	}
+
+	for _, level := range m.levels {
+		value := r.Resource.value(level.def)
+		if value == "" {
+			if !level.matcher.isMatchAny() {
+				return false
+			}
+			continue
+		}
+		if !level.matcher.isMatch(value, r) {
+			return false
+		}
+	}
+
+	for _, child := range m.children {
+		if r.Resource.value(child) != "" {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,144 @@
+package ranger
+
+import "testing"
+
+func TestResourceMatcher(t *testing.T) {
+	path := &ServiceResource{Name: "path", Matcher: PathResourceMatcher}
+	caseSensitive := &ServiceResource{Name: "path", Matcher: PathResourceMatcher, MatcherOptions: MatcherOptions{IgnoreCase: "false"}}
+	noWildcard := &ServiceResource{Name: "bucket", Matcher: DefaultResourceMatcher, MatcherOptions: MatcherOptions{Wildcard: "false"}}
+	bucket := &ServiceResource{Name: "bucket", Matcher: DefaultResourceMatcher}
+
+	r := &AccessRequest{}
+
+	cases := []struct {
+		name     string
+		def      *ServiceResource
+		data     ResourceData
+		resource string
+		want     bool
+	}{
+		{"exact", bucket, ResourceData{Values: []string{"data"}}, "data", true},
+		{"other", bucket, ResourceData{Values: []string{"data"}}, "date", false},
+		{"ignore case", bucket, ResourceData{Values: []string{"Data"}}, "DATA", true},
+		{"case sensitive", caseSensitive, ResourceData{Values: []string{"/Data"}}, "/data", false},
+		{"no values", bucket, ResourceData{}, "anything", true},
+		{"match any", bucket, ResourceData{Values: []string{"*"}}, "anything", true},
+		{"wildcard", bucket, ResourceData{Values: []string{"da?a*"}}, "data1", true},
+		{"wildcard mismatch", bucket, ResourceData{Values: []string{"da?a*"}}, "dta1", false},
+		{"wildcard disabled", noWildcard, ResourceData{Values: []string{"data*"}}, "data1", false},
+		{"wildcard disabled literal", noWildcard, ResourceData{Values: []string{"data*"}}, "data*", true},
+		{"excludes", bucket, ResourceData{Values: []string{"data"}, IsExcludes: true}, "data", false},
+		{"excludes other", bucket, ResourceData{Values: []string{"data"}, IsExcludes: true}, "logs", true},
+
+		{"path", path, ResourceData{Values: []string{"/b/home"}}, "/b/home", true},
+		{"path not recursive", path, ResourceData{Values: []string{"/b/home"}}, "/b/home/x", false},
+		{"recursive self", path, ResourceData{Values: []string{"/b/home"}, IsRecursive: true}, "/b/home", true},
+		{"recursive child", path, ResourceData{Values: []string{"/b/home"}, IsRecursive: true}, "/b/home/x/y", true},
+		{"recursive separator", path, ResourceData{Values: []string{"/b/home/"}, IsRecursive: true}, "/b/home/x", true},
+		{"recursive sibling", path, ResourceData{Values: []string{"/b/home"}, IsRecursive: true}, "/b/homes", false},
+		{"recursive wildcard parent", path, ResourceData{Values: []string{"/b/*.txt"}, IsRecursive: true}, "/b/a.txt/z", true},
+		{"recursive wildcard mismatch", path, ResourceData{Values: []string{"/b/*.txt"}, IsRecursive: true}, "/b/a.doc", false},
+		{"recursive excludes", path, ResourceData{Values: []string{"/b/tmp"}, IsRecursive: true, IsExcludes: true}, "/b/tmp/x", false},
+	}
+
+	for _, c := range cases {
+		m := newResourceMatcher(c.def, c.data)
+		if got := m.isMatch(c.resource, r); got != c.want {
+			t.Errorf("%s: isMatch(%q) with %v = %v, want %v", c.name, c.resource, c.data.Values, got, c.want)
+		}
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		str     string
+		want    bool
+	}{
+		{"*", "", true},
+		{"*", "a/b", true},
+		{"a*b?c", "axxxbyc", true},
+		{"a*b?c", "axxxbc", false},
+		{"?", "", false},
+		{"a*", "b", false},
+		{"*.txt", "dir/a.txt", true},
+		{"a**b", "ab", true},
+	}
+
+	for _, c := range cases {
+		if got := wildcardMatch(c.pattern, c.str); got != c.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", c.pattern, c.str, got, c.want)
+		}
+	}
+}
+
+func TestPolicyResourceMatcher(t *testing.T) {
+	def := &ServiceDefinition{Resources: []ServiceResource{
+		{Name: "bucket", Level: 10, Matcher: DefaultResourceMatcher},
+		{Name: "path", Level: 20, Parent: "bucket", Matcher: PathResourceMatcher, MatcherOptions: MatcherOptions{IgnoreCase: "false"}},
+	}}
+	m := newPolicyResourceMatcher(def, map[string]ResourceData{
+		"bucket": {Values: []string{"data*"}},
+		"path":   {Values: []string{"home/x"}, IsRecursive: true},
+	})
+
+	cases := []struct {
+		bucket string
+		key    string
+		want   bool
+	}{
+		{"data1", "home/x", true},
+		{"data1", "home/x/y", true},
+		{"data1", "home/xy", false},
+		{"DATA1", "home/x", true},
+		{"data1", "Home/x", false},
+		{"other", "home/x", false},
+		{"data1", "", false},
+	}
+
+	for _, c := range cases {
+		r := &AccessRequest{Resource: AccessResource{Bucket: c.bucket, Key: c.key}}
+		if got := m.isMatch(r); got != c.want {
+			t.Errorf("isMatch(%s, %s) = %v, want %v", c.bucket, c.key, got, c.want)
+		}
+	}
+}
+
+func TestResourceMacros(t *testing.T) {
+	path := &ServiceResource{Name: "path", Matcher: PathResourceMatcher}
+
+	r := &AccessRequest{
+		User:           "Bob",
+		UserAttributes: map[string]string{"dept": "fin", "empty": ""},
+		TagAttributes:  map[string]string{"project": "apollo"},
+		Resource:       AccessResource{Owner: "alice"},
+	}
+	anonymous := &AccessRequest{}
+
+	cases := []struct {
+		name     string
+		data     ResourceData
+		resource string
+		r        *AccessRequest
+		want     bool
+	}{
+		{"user macro", ResourceData{Values: []string{"/home/{USER}"}, IsRecursive: true}, "/home/bob/x", r, true},
+		{"user macro other", ResourceData{Values: []string{"/home/{USER}"}, IsRecursive: true}, "/home/alice", r, false},
+		{"owner macro", ResourceData{Values: []string{"/home/{OWNER}"}}, "/home/alice", r, true},
+		{"user attribute macro", ResourceData{Values: []string{"/d/{USER.dept}/*"}}, "/d/fin/a", r, true},
+		{"tag attribute macro", ResourceData{Values: []string{"/p/{TAG.project}"}}, "/p/apollo", r, true},
+		{"escaped macro", ResourceData{Values: []string{"/o/\\{x}"}}, "/o/{x}", r, true},
+		{"unresolved macro", ResourceData{Values: []string{"/home/{USER}"}, IsRecursive: true}, "/home/", anonymous, false},
+		{"unresolved attribute", ResourceData{Values: []string{"/d/{USER.missing}"}}, "/d/", r, false},
+		{"empty expansion", ResourceData{Values: []string{"{USER.empty}"}, IsRecursive: true}, "/b/x", r, false},
+		{"empty expansion empty resource", ResourceData{Values: []string{"{USER.empty}"}, IsRecursive: true}, "", r, true},
+		{"unresolved excludes", ResourceData{Values: []string{"/home/{USER}"}, IsExcludes: true}, "/home/bob", anonymous, true},
+	}
+
+	for _, c := range cases {
+		m := newResourceMatcher(path, c.data)
+		if got := m.isMatch(c.resource, c.r); got != c.want {
+			t.Errorf("%s: isMatch(%q) with %v = %v, want %v", c.name, c.resource, c.data.Values, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package ranger
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ReadPolicyFile loads a service with its policies from a json file in the format
+// of the Ranger policy download, such as the policy cache
+func ReadPolicyFile(path string) (*Service, error) {
+	var service Service
+	if err := readJSONFile(path, &service); err != nil {
+		return nil, err
+	}
+
+	service.prepare()
+	return &service, nil
+}
+
+// WritePolicyFile saves the service with its policies to a json file. The file is
+// replaced atomically so a crash never leaves a partially written cache behind
+func WritePolicyFile(path string, service *Service) error {
+	return writeJSONFile(path, service)
+}
+
+func readJSONFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// writeJSONFile saves v to a json file, replacing the file atomically
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,119 @@
+package ranger
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestFailover(t *testing.T) {
+	var requests [2]int
+	newServer := func(i int, ok *bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests[i]++
+			if !*ok {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintf(w, `{"serviceName":"s3","policyVersion":%d,"policies":[{"id":1}]}`, 10+i)
+		}))
+	}
+	firstUp, secondUp := true, true
+	first, second := newServer(0, &firstUp), newServer(1, &secondUp)
+	defer first.Close()
+	defer second.Close()
+
+	client := NewPolicyClient("s3", []string{first.URL, "http://127.0.0.1:1", second.URL})
+
+	cases := []struct {
+		name     string
+		firstUp  bool
+		secondUp bool
+		version  int    // 0 if the download fails
+		requests [2]int // requests to the servers
+	}{
+		{"first url", true, true, 10, [2]int{1, 0}},
+		{"first url down", false, true, 11, [2]int{1, 1}},
+		{"stays on the working url", true, true, 11, [2]int{0, 1}},
+		{"wraps around", true, false, 10, [2]int{1, 1}},
+		{"all down", false, false, 0, [2]int{1, 1}},
+	}
+
+	for _, c := range cases {
+		firstUp, secondUp = c.firstUp, c.secondUp
+		requests = [2]int{}
+
+		service, err := client.Download(nil)
+		if c.version == 0 {
+			if err == nil {
+				t.Errorf("%s: downloaded version %d, want an error", c.name, service.PolicyVersion)
+			}
+		} else if err != nil || service.PolicyVersion != c.version {
+			t.Errorf("%s: version %v error %v, want %d", c.name, service, err, c.version)
+		}
+		if requests != c.requests {
+			t.Errorf("%s: requests %v, want %v", c.name, requests, c.requests)
+		}
+	}
+}
+
+func TestLoadCache(t *testing.T) {
+	dir := t.TempDir()
+	cached := &Service{ServiceName: "s3", PolicyVersion: 3, Policies: []Policy{{Id: 1}}}
+	cacheFile := filepath.Join(dir, "policies.json")
+	if err := WritePolicyFile(cacheFile, cached); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name      string
+		cacheFile string
+		current   *Service // downloaded before the cache is loaded
+		version   int      // 0 if there are no policies
+		err       bool
+	}{
+		{"cache", cacheFile, nil, 3, false},
+		{"downloaded", cacheFile, &Service{PolicyVersion: 5}, 5, false},
+		{"no cache", filepath.Join(dir, "missing.json"), nil, 0, true},
+	}
+
+	for _, c := range cases {
+		refresher := NewPolicyRefresher(NewPolicyClient("s3", nil), 0, 0)
+		refresher.CacheFile = c.cacheFile
+		if c.current != nil {
+			refresher.service.Store(c.current)
+		}
+
+		err := refresher.LoadCache()
+		if (err != nil) != c.err {
+			t.Errorf("%s: error %v", c.name, err)
+		}
+		service := refresher.Service()
+		if (service == nil) != (c.version == 0) || (service != nil && service.PolicyVersion != c.version) {
+			t.Errorf("%s: policies %v, want version %d", c.name, service, c.version)
+		}
+		if c.version == 3 && refresher.Staleness() <= 0 {
+			t.Errorf("%s: cached policies are not stale", c.name)
+		}
+	}
+}
+
+func TestRefreshWritesCache(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"serviceName":"s3","policyVersion":7,"policies":[{"id":1}]}`)
+	}))
+	defer srv.Close()
+
+	refresher := NewPolicyRefresher(NewPolicyClient("s3", []string{srv.URL}), 0, 0)
+	refresher.CacheFile = filepath.Join(t.TempDir(), "policies.json")
+	if err := refresher.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := ReadPolicyFile(refresher.CacheFile)
+	if err != nil || service.PolicyVersion != 7 {
+		t.Errorf("cached policies %v error %v, want version 7", service, err)
+	}
+}
@@ -0,0 +1,65 @@
+package ranger
+
+import "testing"
+
+func TestPolicyPriority(t *testing.T) {
+	def := ServiceDefinition{
+		Name:        "s3",
+		AccessTypes: []AccessTypes{{Name: "read"}},
+		Resources:   []ServiceResource{{Name: "path", Matcher: PathResourceMatcher}},
+	}
+	tagDef := ServiceDefinition{Name: "tag", Resources: []ServiceResource{{Name: ResourceTag}}}
+
+	path := map[string]ResourceData{"path": {Values: []string{"/x"}, IsRecursive: true}}
+	pii := map[string]ResourceData{ResourceTag: {Values: []string{"PII"}}}
+	bob := []PolicyItem{{Users: []string{"bob"}, Accesses: []Access{{Type: "read", IsAllowed: true}}}}
+	tagBob := []PolicyItem{{Users: []string{"bob"}, Accesses: []Access{{Type: "s3:read", IsAllowed: true}}}}
+
+	allow := func(id int, priority int) Policy {
+		return Policy{Id: id, IsEnabled: true, PolicyPriority: priority, Resources: path, PolicyItems: bob}
+	}
+	deny := func(id int, priority int) Policy {
+		return Policy{Id: id, IsEnabled: true, PolicyPriority: priority, Resources: path, DenyPolicyItems: bob}
+	}
+	tagAllow := func(id int, priority int) Policy {
+		return Policy{Id: id, IsEnabled: true, PolicyPriority: priority, Resources: pii, PolicyItems: tagBob}
+	}
+	tagDeny := func(id int, priority int) Policy {
+		return Policy{Id: id, IsEnabled: true, PolicyPriority: priority, Resources: pii, DenyPolicyItems: tagBob}
+	}
+
+	cases := []struct {
+		name     string
+		policies []Policy
+		tags     []Policy
+		allowed  bool
+		policy   int
+	}{
+		{"deny before allow", []Policy{allow(1, 0), deny(2, 0)}, nil, false, 2},
+		{"override allow before normal deny", []Policy{deny(1, 0), allow(2, 1)}, nil, true, 2},
+		{"override deny before override allow", []Policy{allow(1, 1), deny(2, 1)}, nil, false, 2},
+		{"normal allow", []Policy{allow(1, 0)}, nil, true, 1},
+		{"priority above override", []Policy{deny(1, 0), allow(2, 5)}, nil, true, 2},
+		{"tag allow before normal deny", []Policy{deny(1, 0)}, []Policy{tagAllow(9, 0)}, true, 9},
+		{"override deny before tag allow", []Policy{deny(1, 1)}, []Policy{tagAllow(9, 0)}, false, 1},
+		{"override tag allow before override deny", []Policy{deny(1, 1)}, []Policy{tagAllow(9, 1)}, true, 9},
+		{"override allow before tag deny", []Policy{allow(1, 1)}, []Policy{tagDeny(9, 0)}, true, 1},
+		{"tag deny before normal allow", []Policy{allow(1, 0)}, []Policy{tagDeny(9, 0)}, false, 9},
+	}
+
+	for _, c := range cases {
+		s := &Service{ServiceName: "s3", ServiceDef: def, Policies: c.policies}
+		r := &AccessRequest{User: "bob", AccessType: "read", Resource: AccessResource{Location: "/x/y"}}
+		if c.tags != nil {
+			s.TagPolicies = &Service{ServiceName: "tags", ServiceDef: tagDef, Policies: c.tags}
+			r.Tags = []Tag{{Type: "PII"}}
+		}
+		s.prepare()
+
+		result := s.Evaluate(r)
+		if result.IsAllowed != c.allowed || result.PolicyId != c.policy {
+			t.Errorf("%s: allowed=%v policy=%d, want %v %d (%s)", c.name, result.IsAllowed, result.PolicyId,
+				c.allowed, c.policy, result.Reason)
+		}
+	}
+}
@@ -2,21 +2,20 @@ package ranger
 
 import (
 	"net/http"
-	"io/ioutil"
-	"encoding/json"
 	"sort"
-	"github.com/ryanuber/go-glob"
 	"log"
 	"math"
 	"time"
-	"errors"
-	"net"
 )
 
 const (
 	policyEndpoint = "/service/plugins/policies/download/"
 	policySecureEndpoint = "/service/plugins/secure/policies/download/"
 	pluginId = "pluginId"
+	lastKnownVersion = "lastKnownVersion"
+	lastActivationTime = "lastActivationTime"
+	clusterName = "clusterName"
+	supportsPolicyDeltas = "supportsPolicyDeltas"
 )
 
 type ResourceData struct {
@@ -34,12 +33,16 @@ type Access struct {
 type Condition struct {
 	Type string
 	Values []string
+
+	expressions []expression // non json, compiled values of an expression condition
+	compileErr error // non json
 }
 
 type PolicyItem struct {
 	Accesses []Access
 	Users []string
 	Groups []string
+	Roles []string
 	Conditions []Condition
 	DelegateAdmin bool
 
@@ -54,6 +57,7 @@ type Policy struct {
 	Service string
 	Name string
 	PolicyType int
+	PolicyPriority int
 	Description string
 	IsAuditEnabled bool
 	Resources map[string]ResourceData
@@ -64,6 +68,12 @@ type Policy struct {
 	DataMaskPolicyItems []PolicyItem
 	RowFilterPolicyItems []PolicyItem
 	PolicyLabels []string
+	ZoneName string
+	ValiditySchedules []ValiditySchedule
+
+	matcher *policyResourceMatcher // non json
+	schedules []*validitySchedule // non json
+	invalidSchedule bool // non json
 }
 
 type ServiceOptions struct {
@@ -85,6 +95,12 @@ type ServiceConfig struct {
 type MatcherOptions struct {
 	Wildcard string
 	IgnoreCase string
+	PathSeparatorChar string
+	ReplaceTokens string
+	TokenDelimiterStart string
+	TokenDelimiterEnd string
+	TokenDelimiterEscape string
+	TokenDelimiterPrefix string
 }
 
 type AccessTypes struct {
@@ -99,6 +115,7 @@ type ServiceResource struct {
 	Name string
 	Type string
 	Level int
+	Parent string
 	Mandatory bool
 	LookupSupported bool
 	RecursiveSupported bool
@@ -156,13 +173,22 @@ type ServiceDefinition struct {
 	Resources []ServiceResource
 	AccessTypes []AccessTypes
 	PolicyConditions []PolicyCondition
-	ContextEnrichers []string
+	ContextEnrichers []ContextEnricherDef
 	Enums []string
 	DataMaskDef DataMaskDef
 	RowFilterDef RowFilterDef
 	AuditMode string
 }
 
+// ContextEnricherDef is a context enricher of the service definition, the Java class of
+// the enricher is mapped to the enrichers of the gateway
+type ContextEnricherDef struct {
+	ItemId int
+	Name string
+	Enricher string
+	EnricherOptions map[string]string
+}
+
 type Service struct {
 	ServiceName string
 	ServiceId int
@@ -170,11 +196,56 @@ type Service struct {
 	PolicyUpdateTime int64
 	Policies []Policy
 	ServiceDef ServiceDefinition
+	TagPolicies *Service // policies of the linked tag service
+	AuditMode string
+	PolicyDeltas []PolicyDelta
+	SecurityZones map[string]*SecurityZone // zoned policies by zone name
+
+	conditionDefs map[string]*PolicyCondition // non json
+}
+
+// AccessResult is the outcome of the evaluation of a request
+type AccessResult struct {
+	IsAllowed bool
+	IsAudited bool
+	PolicyId int // policy that determined the outcome, -1 if none did
+	PolicyName string
+	PolicyVersion int
+	PolicyPriority int
+	ServiceName string
+	ServiceVersion int // version of the policies of the service
+	Item string // policy item that determined the outcome, e.g. denyPolicyItems[0]
+	Exception string // exception that overruled a matching allow item, if no policy determined the outcome
+	Condition string // condition not met by an otherwise matching allow item, if no policy determined the outcome
+	Tag string // tag of the tag based policy that determined the outcome
+	Zones []string // security zones of the resource
+	Reason string // explanation of the outcome
+}
+
+func (ar *AccessResult) allow(p *Policy, item string) {
+	ar.IsAllowed = true
+	ar.PolicyId = p.Id
+	ar.PolicyName = p.Name
+	ar.PolicyVersion = p.Version
+	ar.PolicyPriority = p.priority()
+	ar.Item = item
+}
+
+func (ar *AccessResult) deny(p *Policy, item string) {
+	ar.IsAllowed = false
+	ar.PolicyId = p.Id
+	ar.PolicyName = p.Name
+	ar.PolicyVersion = p.Version
+	ar.PolicyPriority = p.priority()
+	ar.Item = item
 }
 
 type AccessResource struct {
 	Owner string
-	Location string // Elements map[string]interface{}
+	Location string
+	Bucket string
+	Key string
+	Elements map[string]string // overrides the value of a resource by name
 }
 
 type AccessRequest struct {
@@ -182,6 +253,7 @@ type AccessRequest struct {
 	AccessType string
 	User string
 	UserGroups []string
+	UserRoles []string
 	AccessTime time.Time
 	ClientIpAddress string
 	ForwardedAdresses []string
@@ -191,7 +263,11 @@ type AccessRequest struct {
 	RequestData string
 	SessionId string
 	Context map[string]interface{}
+	Headers http.Header
 	ClusterName string
+	Tags []Tag
+	UserAttributes map[string]string // resolved by {USER.<attribute>}
+	TagAttributes map[string]string // attributes of the tag being evaluated, resolved by {TAG.<attribute>}
 }
 
 const (
@@ -215,6 +291,12 @@ const (
 	CUSTOM_CONDITION_PENALTY = 5
 	DYNAMIC_RESOURCE_EVAL_PENALTY = 20
 
+	PolicyPriorityNormal = 0
+	PolicyPriorityOverride = 1
+
+	AuditModeAll = "audit-all"
+	AuditModeNone = "audit-none"
+
 	GroupPublic = "public"
 	UserCurrent = "{USER}"
 	UserOwner   = "{OWNER}"
@@ -228,37 +310,7 @@ const (
 
 // GetPolicy loads the service definition and resource policies from Ranger
 func GetPolicy(serviceName string, baseUrl string) (*Service, error) {
-	client := http.Client{}
-	req, err := http.NewRequest("GET", baseUrl + policyEndpoint + serviceName, nil)
-
-	if err != nil {
-		log.Fatal("Request to Apache Ranger failed", err)
-		return nil, err
-	}
-
-	params := req.URL.Query()
-	params.Add(pluginId, serviceName + string("@bla"))
-
-	req.URL.RawQuery = params.Encode()
-	resp, err := client.Do(req)
-
-	if err != nil {
-		log.Fatal("Request to Apache Ranger failed", err)
-		return nil, err
-	}
-
-	data, _ := ioutil.ReadAll(resp.Body)
-
-	var service Service
-
-	err = json.Unmarshal(data, &service)
-
-	if err != nil {
-		log.Fatal("Could not unmarshal json data", err)
-		return nil, err
-	}
-
-	return &service, nil
+	return NewPolicyClient(serviceName, []string{baseUrl}).Download(nil)
 }
 
 // checks if an array of strings contains a specific string
@@ -278,7 +330,7 @@ func (pi *PolicyItem) computeEvalScore(service ServiceDefinition) {
 	if contains(pi.Groups, GroupPublic) {
 		score -= DISCOUNT_USERSGROUPS
 	} else {
-		count := len(pi.Users) + len(pi.Groups)
+		count := len(pi.Users) + len(pi.Groups) + len(pi.Roles)
 		score -= int(math.Min(float64(DISCOUNT_USERSGROUPS), float64(count)))
 	}
 
@@ -310,180 +362,313 @@ func hasAccess(names []string, other []string, accesses []Access, accessType str
 	return false
 }
 
-// Check all IPs (remote, client, forward addresses) supplied in the chain from the
-// client are within the ranges specified
-func (c *Condition) isInCondition(r *AccessRequest)(error, bool) {
-	if c.Type != "ipaddress-in-range" {
-		return errors.New("Unknown condition:" + c.Type), false
+// prepare sorts the policies and creates the resource matchers, it needs to be called
+// before the service is used to evaluate requests. Policies that have been prepared
+// before are shared with the previous version of the service and are left untouched
+func (s *Service) prepare() {
+	implied := s.ServiceDef.impliedGrants()
+
+	s.conditionDefs = make(map[string]*PolicyCondition)
+	for i := range s.ServiceDef.PolicyConditions {
+		s.conditionDefs[s.ServiceDef.PolicyConditions[i].Name] = &s.ServiceDef.PolicyConditions[i]
 	}
 
-	var clientIpOk, remoteOk bool
-	for _, cidr := range c.Values {
-		clientIpOk, remoteOk = false, false
-		_, subnet, err := net.ParseCIDR(cidr)
-		if err != nil {
-			log.Printf("Invalid cidr=%s\n", cidr)
-			continue
+	preparePolicies(&s.ServiceDef, s.Policies, implied)
+	for _, zone := range s.SecurityZones {
+		zone.prepare(&s.ServiceDef, implied)
+	}
+
+	if s.TagPolicies != nil {
+		s.TagPolicies.prepare()
+	}
+}
+
+// preparePolicies sorts the policies, creates their resource matchers and expands the
+// implied grants of their items
+func preparePolicies(serviceDef *ServiceDefinition, policies []Policy, implied map[string][]string) {
+	// TODO: Sort on importance of policy within a priority
+	sort.SliceStable(policies, func(i, j int) bool {
+		if policies[i].priority() != policies[j].priority() {
+			return policies[i].priority() > policies[j].priority()
 		}
+		return policies[i].Id < policies[j].Id
+	})
+
+	conditionDefs := make(map[string]*PolicyCondition)
+	for i := range serviceDef.PolicyConditions {
+		conditionDefs[serviceDef.PolicyConditions[i].Name] = &serviceDef.PolicyConditions[i]
+	}
 
-		// check client ip
-		ip := net.ParseIP(r.ClientIpAddress)
-		log.Printf("Checking clientIp=%s cidr=%s\n", ip.String(), subnet.String())
-		if subnet.Contains(ip) {
-			log.Printf("subnet=%s contains ip=%s\n", subnet.String(), ip.String())
-			clientIpOk = true
+	for i := range policies {
+		p := &policies[i]
+		if p.matcher != nil {
+			// prepared before and carried over by a delta
+			continue
 		}
+		p.matcher = newPolicyResourceMatcher(serviceDef, p.Resources)
+		p.prepareSchedules()
+
+		for _, items := range [][]PolicyItem{p.PolicyItems, p.DenyPolicyItems, p.AllowExceptions, p.DenyExceptions} {
+			for j := range items {
+				items[j].Accesses = expandAccesses(items[j].Accesses, implied)
+				for k := range items[j].Conditions {
+					condition := &items[j].Conditions[k]
+					condition.prepare(conditionDefs[condition.Type])
+				}
+			}
+		}
+	}
+}
 
-		// check remote ip
-		ip = net.ParseIP(r.RemoteIpAddress)
-		log.Printf("Checking remoteAddr=%s cidr=%s\n", ip.String(), subnet.String())
-		if subnet.Contains(ip) {
-			log.Printf("subnet=%s contains ip=%s\n", subnet.String(), ip.String())
-			remoteOk = true
+// impliedGrants returns for every access type all access types it implies, directly
+// or through other implied access types
+func (sd *ServiceDefinition) impliedGrants() map[string][]string {
+	direct := make(map[string][]string)
+	for _, accessType := range sd.AccessTypes {
+		direct[accessType.Name] = accessType.ImpliedGrants
+	}
+
+	implied := make(map[string][]string)
+	for name := range direct {
+		seen := map[string]bool{name: true}
+		pending := append([]string{}, direct[name]...)
+		for len(pending) > 0 {
+			grant := pending[0]
+			pending = pending[1:]
+			if seen[grant] {
+				continue
+			}
+			seen[grant] = true
+			implied[name] = append(implied[name], grant)
+			pending = append(pending, direct[grant]...)
 		}
+	}
 
-		if clientIpOk && remoteOk {
-			break
+	return implied
+}
+
+// expandAccesses adds the implied access types to the accesses of a policy item. An
+// access that is explicitly listed in the item is left as is
+func expandAccesses(accesses []Access, implied map[string][]string) []Access {
+	expanded := accesses
+	for _, access := range accesses {
+		for _, grant := range implied[access.Type] {
+			found := false
+			for _, other := range expanded {
+				if other.Type == grant {
+					found = true
+					break
+				}
+			}
+			if !found {
+				expanded = append(expanded, Access{Type: grant, IsAllowed: access.IsAllowed})
+			}
 		}
 	}
 
-	if !clientIpOk || !remoteOk {
-		return nil, false
+	return expanded
+}
+
+// isDenyAndExceptionsEnabled returns false if the service definition disables deny
+// items and exceptions in its policies
+func (s *Service) isDenyAndExceptionsEnabled() bool {
+	return optionEnabled(s.ServiceDef.Options.EnableDenyAndExceptionsInPolicies, true)
+}
+
+// priority returns if the policy is an override or a normal policy
+func (p *Policy) priority() int {
+	if p.PolicyPriority >= PolicyPriorityOverride {
+		return PolicyPriorityOverride
 	}
+	return PolicyPriorityNormal
+}
 
-	// no forward addresses
-	if len(r.ForwardedAdresses) == 0 {
-		return nil, true
+// isResourceMatch checks if the resources of the policy match the resource of the request
+func (p *Policy) isResourceMatch(serviceDef *ServiceDefinition, r *AccessRequest) bool {
+	matcher := p.matcher
+	if matcher == nil {
+		matcher = newPolicyResourceMatcher(serviceDef, p.Resources)
 	}
 
-	// check forwarded ip addresses. *all* need to be in range
-	fwdsOk := false
-	for _, fwdAddress := range r.ForwardedAdresses {
-		for _, cidr := range c.Values {
-			// error was already caught above
-			_, subnet, _ := net.ParseCIDR(cidr)
-
-			log.Printf("Checking forwarded address=%s cidr=%s\n", fwdAddress, subnet.String())
-			ip := net.ParseIP(fwdAddress)
-			if ip == nil {
-				log.Printf("Invalid forward address=%s received\n", fwdAddress)
-				return errors.New("Invalid forward address " + fwdAddress), false
+	return matcher.isMatch(r)
+}
+
+// isMatch checks if the user and groups, access type and conditions of the item match the
+// request. All conditions of the item need to be met
+func (pi *PolicyItem) isMatch(s *Service, r *AccessRequest) bool {
+	match, _ := pi.match(s, r)
+	return match
+}
+
+// match checks if the item matches the request like isMatch. If only a condition is not
+// met it also returns the type of that condition
+func (pi *PolicyItem) match(s *Service, r *AccessRequest) (bool, string) {
+	groups := r.UserGroups
+	if contains(pi.Groups, GroupPublic) {
+		groups = []string{GroupPublic}
+	}
+
+	if !hasAccess([]string{r.User}, pi.Users, pi.Accesses, r.AccessType, r.User == r.Resource.Owner) &&
+		!hasAccess(groups, pi.Groups, pi.Accesses, r.AccessType, false) &&
+		!hasAccess(r.UserRoles, pi.Roles, pi.Accesses, r.AccessType, false) {
+		return false, ""
+	}
+
+	for i := range pi.Conditions {
+		condition := &pi.Conditions[i]
+		found, err := condition.isMatch(s.conditionDefs[condition.Type], r)
+		if err != nil {
+			log.Printf("Error checking condition=%s err=%s\n", condition.Type, err)
+		}
+		if !found {
+			log.Printf("policyItem users=%s, groups=%s condition=%s not met\n", pi.Users, pi.Groups, condition.Type)
+			return false, condition.Type
+		}
+	}
+
+	return true, ""
+}
+
+// isItemsMatch checks if any of the items matches the request and is not excepted
+func (s *Service) isItemsMatch(items []PolicyItem, exceptions []PolicyItem, r *AccessRequest) bool {
+	return s.matchItems(items, exceptions, r).isMatch()
+}
+
+// matchItems returns the first of the items that matches the request and the exception
+// that overrules it, if any
+func (s *Service) matchItems(items []PolicyItem, exceptions []PolicyItem, r *AccessRequest) itemsMatch {
+	m := itemsMatch{item: -1, exception: -1, conditionItem: -1}
+	for i := range items {
+		match, condition := items[i].match(s, r)
+		if !match {
+			if condition != "" && m.conditionItem < 0 {
+				m.conditionItem = i
+				m.condition = condition
 			}
-			if subnet.Contains(ip) {
-				log.Printf("subnet=%s contains ip=%s\n", subnet.String(), ip.String())
-				fwdsOk = true
+			continue
+		}
+		m.item = i
+		for j := range exceptions {
+			if exceptions[j].isMatch(s, r) {
+				m.exception = j
 				break
-			} else {
-				fwdsOk = false
 			}
 		}
+		return m
 	}
 
-	if fwdsOk {
-		return nil, true
-	}
-
-	return nil, false
+	return m
 }
 
 // IsAccessAllowed checks if a user is allowed by policy to access the resource location.
 func (s *Service) IsAccessAllowed(r *AccessRequest)(bool) {
-	// TODO: Sort on importance of policy
-	sort.SliceStable(s.Policies, func(i, j int) bool {return s.Policies[i].Id < s.Policies[j].Id})
+	return s.Evaluate(r).IsAllowed
+}
 
+// Evaluate checks the policies for the request. Override policies are evaluated before
+// normal policies and their decision is final. Tag based policies are evaluated first,
+// their decision is final unless it is made by a normal policy and an override resource
+// policy decides otherwise. A matching deny item overrides allow items of policies of
+// the same priority.
+func (s *Service) Evaluate(r *AccessRequest)(*AccessResult) {
 	log.Printf("Checking policy for user=%s, groups=%s, access=%s, location=%s\n",
 		r.User, r.UserGroups, r.AccessType, r.Resource.Location)
 
-	allowed := false
-	resourceMatch := false
+	result := &AccessResult{
+		ServiceName: s.ServiceName,
+		ServiceVersion: s.PolicyVersion,
+		PolicyId: -1,
+	}
 
-	for _, p := range s.Policies {
-		// match resource
-		for _, v := range p.Resources {
-			for _, bucketName := range v.Values {
-				if v.IsRecursive {
-					bucketName += "*"
-				}
-				if glob.Glob(bucketName, r.Resource.Location) {
-					resourceMatch = true
-				}
+	result.Zones = s.zonesOf(r)
+
+	if s.evaluateTags(r, result) {
+		log.Printf("Access allowed=%t by tag policies for tags=%d\n", result.IsAllowed, len(r.Tags))
+		if result.PolicyPriority < PolicyPriorityOverride {
+			override := &AccessResult{PolicyId: -1, Zones: result.Zones}
+			if s.evaluatePriority(r, override, PolicyPriorityOverride) {
+				log.Printf("Access allowed=%t by override policy id=%d\n", override.IsAllowed, override.PolicyId)
+				result.decide(override, "")
 			}
+			result.IsAudited = result.IsAudited || override.IsAudited
 		}
+	} else {
+		s.evaluate(r, result)
+	}
+	result.explain()
 
-		log.Printf("Policy id=%d, name=%s, resource_match=%s\n", p.Id, p.Name, resourceMatch)
+	switch s.AuditMode {
+	case AuditModeAll:
+		result.IsAudited = true
+	case AuditModeNone:
+		result.IsAudited = false
+	}
 
-		if !resourceMatch {
-			continue
-		}
+	return result
+}
 
-		// We have a resource match
+// evaluate checks the resource policies of the service for the security zones of the
+// result and records the outcome in the result. It returns if any policy determined the
+// outcome
+func (s *Service) evaluate(r *AccessRequest, result *AccessResult)(bool) {
+	for _, priority := range []int{PolicyPriorityOverride, PolicyPriorityNormal} {
+		if s.evaluatePriority(r, result, priority) {
+			return true
+		}
+	}
 
-		log.Printf("Checking allow policy items=%d\n", len(p.PolicyItems))
-		for _, item := range p.PolicyItems {
-			// user first
-			allowed = hasAccess([]string{r.User}, item.Users, item.Accesses, r.AccessType, r.User == r.Resource.Owner)
+	return false
+}
 
-			// groups
-			if !allowed {
-				allowed = hasAccess(r.UserGroups, item.Groups, item.Accesses, r.AccessType, false)
-			}
+// evaluatePriority checks the policies of the given priority like evaluate
+func (s *Service) evaluatePriority(r *AccessRequest, result *AccessResult, priority int)(bool) {
+	denyEnabled := s.isDenyAndExceptionsEnabled()
 
-			// conditions
-			if allowed {
-				log.Printf("Checking allow policy conditions\n")
-				found := false
-				for _, condition := range item.Conditions {
-					var err error
-					err, found = condition.isInCondition(r)
-					if err != nil {
-						log.Printf("Error checking condition=%s err=%s\n", condition, err)
-					}
-					if found {
-						break
-					}
-				}
-				if !found && len(item.Conditions) > 0 {
-					log.Printf("policyItem=%s conditions not met\n", item)
-					allowed = false
-				}
+	for _, policies := range s.policiesFor(result.Zones) {
+		for i := range policies {
+			p := &policies[i]
+			if p.priority() != priority || !p.IsEnabled || !p.isValid(r.AccessTime) {
+				continue
 			}
 
-			// TODO: check exceptions
-		}
+			resourceMatch := p.isResourceMatch(&s.ServiceDef, r)
+			log.Printf("Policy id=%d, name=%s, resource_match=%t\n", p.Id, p.Name, resourceMatch)
 
-		log.Printf("Checking deny policy items=%d\n", len(p.DenyPolicyItems))
-
-		for _, item := range p.DenyPolicyItems {
-			// allowed signals denial
-			allowed = !hasAccess([]string{r.User}, item.Users, item.Accesses, r.AccessType, r.User == r.Resource.Owner)
+			if !resourceMatch {
+				continue
+			}
 
-			// groups
-			if allowed {
-				allowed = !hasAccess(r.UserGroups, item.Groups, item.Accesses, r.AccessType, false)
+			if p.IsAuditEnabled {
+				result.IsAudited = true
 			}
 
-			// conditions
-			if !allowed {
-				log.Printf("Checking deny policy conditions\n")
-				found := false
-				for _, condition := range item.Conditions {
-					_, found = condition.isInCondition(r)
-					if found {
-						break
-					}
-				}
-				if !found && len(item.Conditions) > 0 {
-					allowed = true
+			if denyEnabled {
+				log.Printf("Checking deny policy items=%d\n", len(p.DenyPolicyItems))
+				m := s.matchItems(p.DenyPolicyItems, p.DenyExceptions, r)
+				if m.isMatch() {
+					log.Printf("Access denied by policy id=%d, name=%s\n", p.Id, p.Name)
+					result.deny(p, itemName(itemsDeny, m.item))
+					return true
 				}
 			}
 
-			// TODO: check exceptions
-		}
+			if result.IsAllowed {
+				continue
+			}
 
-		// if we got here we had a resource match
-		break
+			log.Printf("Checking allow policy items=%d\n", len(p.PolicyItems))
+			exceptions := p.AllowExceptions
+			if !denyEnabled {
+				exceptions = nil
+			}
+			m := s.matchItems(p.PolicyItems, exceptions, r)
+			if m.isMatch() {
+				result.allow(p, itemName(itemsAllow, m.item))
+				continue
+			}
+			result.nearMiss(p, m, itemsAllow, itemsAllowExceptions)
+		}
 	}
 
-	return allowed
+	return result.IsAllowed
 }
-
@@ -0,0 +1,364 @@
+package ranger
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultRefreshInterval = 30 * time.Second
+	defaultMaxBackoff      = 5 * time.Minute
+	defaultJitter          = 0.1
+	defaultHistory         = 32
+)
+
+// PolicyRefresher keeps the policies of a service up to date. Failed downloads are
+// retried with exponential backoff and never replace the current policies
+type PolicyRefresher struct {
+	Client     *PolicyClient
+	Interval   time.Duration
+	MaxBackoff time.Duration
+	Jitter     float64 // fraction of the interval to randomize refreshes with
+	CacheFile  string  // policies are persisted here after every download if set
+
+	SyncRoles     bool // also download the roles of the service
+	SyncUserStore bool // also download the users and groups of Ranger admin
+	Strict        bool // refuse to activate policies that fail validation
+	History       int  // number of policy diffs that are kept
+
+	service     atomic.Value
+	roles       atomic.Value
+	userStore   atomic.Value
+	problems    atomic.Value
+	failures    int64
+	rejected    int64 // policy versions refused in strict mode
+	lastRefresh int64 // unix nanoseconds of the last successful contact with Ranger
+
+	historyMu sync.Mutex
+	history   []*PolicyDiff
+}
+
+func NewPolicyRefresher(client *PolicyClient, interval time.Duration, maxBackoff time.Duration) *PolicyRefresher {
+	if interval <= 0 {
+		interval = defaultRefreshInterval
+	}
+	if maxBackoff < interval {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	return &PolicyRefresher{
+		Client:     client,
+		Interval:   interval,
+		MaxBackoff: maxBackoff,
+		Jitter:     defaultJitter,
+		History:    defaultHistory,
+	}
+}
+
+// Service returns the current policies, nil if they have not been loaded yet
+func (r *PolicyRefresher) Service() *Service {
+	service, _ := r.service.Load().(*Service)
+	return service
+}
+
+// Roles returns the current roles, nil if they are not synced or not loaded yet
+func (r *PolicyRefresher) Roles() *Roles {
+	roles, _ := r.roles.Load().(*Roles)
+	return roles
+}
+
+// UserStore returns the current user store, nil if it is not synced or not loaded yet
+func (r *PolicyRefresher) UserStore() *UserStore {
+	store, _ := r.userStore.Load().(*UserStore)
+	return store
+}
+
+// Problems returns the validation problems of the last downloaded or cached policies,
+// which are not active if Strict is set
+func (r *PolicyRefresher) Problems() []PolicyProblem {
+	problems, _ := r.problems.Load().([]PolicyProblem)
+	return problems
+}
+
+// Rejected returns the number of policy versions that were refused in strict mode
+func (r *PolicyRefresher) Rejected() int64 {
+	return atomic.LoadInt64(&r.rejected)
+}
+
+// validate checks the policies before they are activated, in strict mode policies with
+// problems are refused
+func (r *PolicyRefresher) validate(service *Service) error {
+	problems := service.Validate()
+	for _, problem := range problems {
+		log.Printf("Invalid %s\n", problem)
+	}
+	r.problems.Store(problems)
+
+	if r.Strict && len(problems) > 0 {
+		atomic.AddInt64(&r.rejected, 1)
+		return fmt.Errorf("Refusing policies for service=%s version=%d with %d problems in strict mode",
+			service.ServiceName, service.PolicyVersion, len(problems))
+	}
+
+	return nil
+}
+
+// Changes returns the diffs of the last activations of new policies, the latest first
+func (r *PolicyRefresher) Changes() []*PolicyDiff {
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+
+	changes := make([]*PolicyDiff, len(r.history))
+	for i, diff := range r.history {
+		changes[len(r.history)-1-i] = diff
+	}
+	return changes
+}
+
+// record logs the changes of an activation and adds them to the bounded history
+func (r *PolicyRefresher) record(diff *PolicyDiff) {
+	for _, c := range diff.Changes {
+		log.Printf("Policy change service=%s zone=%s change=%s id=%d name=%s oldVersion=%d newVersion=%d policyVersion=%d tagVersion=%d\n",
+			c.Service, c.Zone, c.Change, c.PolicyId, c.PolicyName, c.OldVersion, c.NewVersion, diff.ToVersion, diff.ToTagVersion)
+	}
+
+	if r.History <= 0 {
+		return
+	}
+
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+
+	r.history = append(r.history, diff)
+	if len(r.history) > r.History {
+		r.history = append([]*PolicyDiff(nil), r.history[len(r.history)-r.History:]...)
+	}
+}
+
+// LastRefresh returns when Ranger was last contacted successfully, zero if never
+func (r *PolicyRefresher) LastRefresh() time.Time {
+	nanos := atomic.LoadInt64(&r.lastRefresh)
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// Staleness returns how long the policies have not been confirmed by Ranger
+func (r *PolicyRefresher) Staleness() time.Duration {
+	last := r.LastRefresh()
+	if last.IsZero() {
+		return 0
+	}
+	return time.Since(last)
+}
+
+// Failures returns the number of consecutive failed refreshes
+func (r *PolicyRefresher) Failures() int64 {
+	return atomic.LoadInt64(&r.failures)
+}
+
+// Refresh downloads the policies, and the roles and user store if they are synced, once
+// and activates them if they changed
+func (r *PolicyRefresher) Refresh() error {
+	err := r.refreshPolicies()
+
+	if r.SyncRoles {
+		if rolesErr := r.refreshRoles(); err == nil {
+			err = rolesErr
+		}
+	}
+
+	if r.SyncUserStore {
+		if storeErr := r.refreshUserStore(); err == nil {
+			err = storeErr
+		}
+	}
+
+	return err
+}
+
+func (r *PolicyRefresher) refreshPolicies() error {
+	current := r.Service()
+
+	service, err := r.Client.Download(current)
+	if err == ErrNotModified {
+		atomic.StoreInt64(&r.lastRefresh, time.Now().UnixNano())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// every download is validated, it may only change the tag policies
+	if err := r.validate(service); err != nil {
+		return err
+	}
+
+	if current == nil || current.PolicyVersion != service.PolicyVersion {
+		log.Printf("Activating policies for service=%s version=%d\n", service.ServiceName, service.PolicyVersion)
+	}
+	// the download may only change the tag policies, so the policies are always compared
+	if current != nil {
+		if diff := Diff(current, service); len(diff.Changes) > 0 {
+			r.record(diff)
+		}
+	}
+	r.service.Store(service)
+	atomic.StoreInt64(&r.lastRefresh, time.Now().UnixNano())
+
+	if r.CacheFile != "" {
+		if err := WritePolicyFile(r.CacheFile, service); err != nil {
+			log.Printf("Cannot write policy cache=%s due to error %s\n", r.CacheFile, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *PolicyRefresher) refreshRoles() error {
+	current := r.Roles()
+
+	roles, err := r.Client.DownloadRoles(current)
+	if err == ErrNotModified {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Activating roles for service=%s version=%d roles=%d\n", r.Client.ServiceName, roles.RoleVersion, len(roles.RangerRoles))
+	r.roles.Store(roles)
+
+	if r.CacheFile != "" {
+		if err := writeJSONFile(r.rolesCacheFile(), roles); err != nil {
+			log.Printf("Cannot write roles cache=%s due to error %s\n", r.rolesCacheFile(), err)
+		}
+	}
+
+	return nil
+}
+
+func (r *PolicyRefresher) refreshUserStore() error {
+	current := r.UserStore()
+
+	store, err := r.Client.DownloadUserStore(current)
+	if err == ErrNotModified {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Activating user store version=%d users=%d\n", store.UserStoreVersion, len(store.UserGroupMapping))
+	r.userStore.Store(store)
+
+	if r.CacheFile != "" {
+		if err := writeJSONFile(r.userStoreCacheFile(), store); err != nil {
+			log.Printf("Cannot write user store cache=%s due to error %s\n", r.userStoreCacheFile(), err)
+		}
+	}
+
+	return nil
+}
+
+// rolesCacheFile and userStoreCacheFile are kept next to the policy cache
+func (r *PolicyRefresher) rolesCacheFile() string {
+	return r.CacheFile + ".roles"
+}
+
+func (r *PolicyRefresher) userStoreCacheFile() string {
+	return r.CacheFile + ".userstore"
+}
+
+// LoadCache activates the cached policies, and the cached roles and user store if they
+// are synced, unless they were downloaded already. It is used when Ranger cannot be
+// reached at startup, the cached data is replaced by the next successful refresh
+func (r *PolicyRefresher) LoadCache() error {
+	if r.SyncRoles && r.Roles() == nil {
+		if roles, err := ReadRolesFile(r.rolesCacheFile()); err == nil {
+			r.roles.Store(roles)
+		} else {
+			log.Printf("Cannot load roles cache=%s due to error %s\n", r.rolesCacheFile(), err)
+		}
+	}
+
+	if r.SyncUserStore && r.UserStore() == nil {
+		if store, err := ReadUserStoreFile(r.userStoreCacheFile()); err == nil {
+			r.userStore.Store(store)
+		} else {
+			log.Printf("Cannot load user store cache=%s due to error %s\n", r.userStoreCacheFile(), err)
+		}
+	}
+
+	if r.Service() != nil {
+		return nil
+	}
+
+	service, err := ReadPolicyFile(r.CacheFile)
+	if err != nil {
+		return err
+	}
+
+	if err = r.validate(service); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(r.CacheFile)
+	if err == nil {
+		atomic.StoreInt64(&r.lastRefresh, info.ModTime().UnixNano())
+	}
+
+	log.Printf("Activating cached policies for service=%s version=%d from=%s\n",
+		service.ServiceName, service.PolicyVersion, r.CacheFile)
+	r.service.Store(service)
+
+	return nil
+}
+
+// next returns the time to wait before the next refresh: the interval after a success
+// and a doubling of it (up to MaxBackoff) for every consecutive failure, randomized
+// by the jitter
+func (r *PolicyRefresher) next() time.Duration {
+	wait := r.Interval
+	for i := int64(0); i < r.Failures() && wait < r.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > r.MaxBackoff {
+		wait = r.MaxBackoff
+	}
+
+	if r.Jitter > 0 {
+		wait += time.Duration((rand.Float64()*2 - 1) * r.Jitter * float64(wait))
+	}
+
+	return wait
+}
+
+// Run refreshes the policies until stop is closed
+func (r *PolicyRefresher) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(r.next()):
+		}
+
+		if err := r.Refresh(); err != nil {
+			failures := atomic.AddInt64(&r.failures, 1)
+			version := -1
+			if service := r.Service(); service != nil {
+				version = service.PolicyVersion
+			}
+			log.Printf("Cannot refresh Ranger policy due to error %s, attempt=%d, enforcing version=%d stale=%s\n",
+				err, failures, version, r.Staleness())
+			continue
+		}
+		atomic.StoreInt64(&r.failures, 0)
+	}
+}
@@ -0,0 +1,138 @@
+package ranger
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+const (
+	rolesEndpoint        = "/service/roles/download/"
+	rolesSecureEndpoint  = "/service/roles/secure/download/"
+	lastKnownRoleVersion = "lastKnownRoleVersion"
+)
+
+// RoleMember is a user, group or role that is a member of a role
+type RoleMember struct {
+	Name    string
+	IsAdmin bool
+}
+
+// Role is a Ranger role, policy items can grant access to its members by its name
+type Role struct {
+	Id          int
+	Name        string
+	Description string
+	IsEnabled   bool
+	Users       []RoleMember
+	Groups      []RoleMember
+	Roles       []RoleMember
+}
+
+// Roles are the roles of a service as they are downloaded from Ranger
+type Roles struct {
+	ServiceName    string
+	RoleVersion    int
+	RoleUpdateTime int64
+	RangerRoles    []Role
+
+	byUser  map[string][]string // non json
+	byGroup map[string][]string // non json
+	byRole  map[string][]string // non json
+}
+
+// prepare indexes the enabled roles by their members, disabled roles grant nothing
+func (rs *Roles) prepare() {
+	rs.byUser = make(map[string][]string)
+	rs.byGroup = make(map[string][]string)
+	rs.byRole = make(map[string][]string)
+
+	for _, role := range rs.RangerRoles {
+		if !role.IsEnabled {
+			continue
+		}
+		for _, user := range role.Users {
+			rs.byUser[user.Name] = append(rs.byUser[user.Name], role.Name)
+		}
+		for _, group := range role.Groups {
+			rs.byGroup[group.Name] = append(rs.byGroup[group.Name], role.Name)
+		}
+		for _, member := range role.Roles {
+			rs.byRole[member.Name] = append(rs.byRole[member.Name], role.Name)
+		}
+	}
+}
+
+// RolesFor returns the roles of a user, directly or through the groups of the user
+// and roles that are members of other roles
+func (rs *Roles) RolesFor(user string, groups []string) []string {
+	if rs == nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var pending []string
+	pending = append(pending, rs.byUser[user]...)
+	pending = append(pending, rs.byGroup[GroupPublic]...)
+	for _, group := range groups {
+		pending = append(pending, rs.byGroup[group]...)
+	}
+
+	var roles []string
+	for len(pending) > 0 {
+		role := pending[0]
+		pending = pending[1:]
+		if seen[role] {
+			continue
+		}
+		seen[role] = true
+		roles = append(roles, role)
+		pending = append(pending, rs.byRole[role]...)
+	}
+
+	return roles
+}
+
+// DownloadRoles loads the roles of the service if they changed since the version of
+// current, which can be nil to load them regardless. It returns ErrNotModified if
+// Ranger reports that the roles did not change
+func (c *PolicyClient) DownloadRoles(current *Roles) (*Roles, error) {
+	endpoint := rolesEndpoint
+	if c.Secure {
+		endpoint = rolesSecureEndpoint
+	}
+
+	version := -1
+	if current != nil {
+		version = current.RoleVersion
+	}
+
+	params := c.params()
+	params.Add(lastKnownRoleVersion, strconv.Itoa(version))
+
+	data, err := c.fetch(endpoint+c.ServiceName, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var roles Roles
+	if err = json.Unmarshal(data, &roles); err != nil {
+		return nil, err
+	}
+	if current != nil && roles.RoleVersion == current.RoleVersion {
+		return nil, ErrNotModified
+	}
+
+	roles.prepare()
+	return &roles, nil
+}
+
+// ReadRolesFile loads roles from a json file in the format of the Ranger download
+func ReadRolesFile(path string) (*Roles, error) {
+	var roles Roles
+	if err := readJSONFile(path, &roles); err != nil {
+		return nil, err
+	}
+
+	roles.prepare()
+	return &roles, nil
+}
@@ -0,0 +1,81 @@
+package ranger
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRolesFor(t *testing.T) {
+	members := func(names ...string) []RoleMember {
+		var members []RoleMember
+		for _, name := range names {
+			members = append(members, RoleMember{Name: name})
+		}
+		return members
+	}
+
+	roles := &Roles{RangerRoles: []Role{
+		{Name: "auditors", IsEnabled: true, Users: members("bob"), Groups: members("finance")},
+		{Name: "readers", IsEnabled: true, Roles: members("auditors")},
+		{Name: "everyone", IsEnabled: true, Groups: members(GroupPublic)},
+		{Name: "all", IsEnabled: true, Roles: members("readers", "admins")},
+		{Name: "admins", IsEnabled: false, Users: members("alice"), Roles: members("all")},
+		{Name: "operators", IsEnabled: true, Roles: members("admins")},
+		{Name: "retired", IsEnabled: false, Users: members("bob")},
+		{Name: "a", IsEnabled: true, Users: members("carol"), Roles: members("b")},
+		{Name: "b", IsEnabled: true, Roles: members("a")},
+	}}
+	roles.prepare()
+
+	cases := []struct {
+		user   string
+		groups []string
+		want   []string
+	}{
+		{"bob", nil, []string{"auditors", "everyone", "readers", "all"}},
+		{"dave", []string{"finance"}, []string{"everyone", "auditors", "readers", "all"}},
+		{"dave", nil, []string{"everyone"}},
+		{"alice", nil, []string{"everyone"}}, // admins is disabled, so is its membership of operators
+		{"carol", nil, []string{"a", "everyone", "b"}},
+	}
+
+	for _, c := range cases {
+		if got := roles.RolesFor(c.user, c.groups); fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("RolesFor(%s, %v) = %v, want %v", c.user, c.groups, got, c.want)
+		}
+	}
+
+	var none *Roles
+	if got := none.RolesFor("bob", nil); got != nil {
+		t.Errorf("RolesFor without roles = %v", got)
+	}
+}
+
+func TestDownloadRoles(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != rolesEndpoint+"s3" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get(lastKnownRoleVersion) == "2" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, `{"serviceName":"s3","roleVersion":2,"rangerRoles":[{"name":"auditors","isEnabled":true,"groups":[{"name":"finance"}]}]}`)
+	}))
+	defer srv.Close()
+
+	client := NewPolicyClient("s3", []string{srv.URL})
+	roles, err := client.DownloadRoles(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := roles.RolesFor("bob", []string{"finance"}); fmt.Sprint(got) != "[auditors]" {
+		t.Errorf("roles of downloaded roles %v", got)
+	}
+	if _, err := client.DownloadRoles(roles); err != ErrNotModified {
+		t.Errorf("download of the same version: error %v, want not modified", err)
+	}
+}
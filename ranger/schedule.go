@@ -0,0 +1,321 @@
+package ranger
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleTimeLayout is the format of the start and end time of a validity schedule
+const scheduleTimeLayout = "2006/01/02 15:04:05"
+
+// ValidityInterval is the time a policy stays valid after a recurrence starts
+type ValidityInterval struct {
+	Days    int
+	Hours   int
+	Minutes int
+}
+
+// ScheduleSpec defines when a recurrence starts, every field is * or a comma separated
+// list of values and ranges (1-5). The month counts from 0 and the day of the week from
+// Sunday as 1, as in Ranger
+type ScheduleSpec struct {
+	Minute     string
+	Hour       string
+	DayOfMonth string
+	DayOfWeek  string
+	Month      string
+	Year       string
+}
+
+type ValidityRecurrence struct {
+	Schedule ScheduleSpec
+	Interval ValidityInterval
+}
+
+// ValiditySchedule limits the time a policy is valid, between the start and end time
+// and, if there are recurrences, during one of them
+type ValiditySchedule struct {
+	StartTime   string
+	EndTime     string
+	TimeZone    string
+	Recurrences []ValidityRecurrence
+}
+
+// fieldSpec is a parsed field of a schedule spec, nil matches any value
+type fieldSpec []bool
+
+// recurrence is a parsed validity recurrence
+type recurrence struct {
+	minute, hour, dayOfMonth, dayOfWeek, month, year fieldSpec
+	interval                                         time.Duration
+}
+
+// validitySchedule is a parsed validity schedule, start and end are zero if not set
+type validitySchedule struct {
+	start       time.Time
+	end         time.Time
+	location    *time.Location
+	recurrences []recurrence
+}
+
+func parseField(spec string, min int, max int) (fieldSpec, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "*" {
+		return nil, nil
+	}
+
+	field := make(fieldSpec, max+1)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		from, to := part, part
+		if pos := strings.Index(part, "-"); pos > 0 {
+			from, to = part[:pos], part[pos+1:]
+		}
+
+		low, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			return nil, err
+		}
+		high, err := strconv.Atoi(strings.TrimSpace(to))
+		if err != nil {
+			return nil, err
+		}
+		if low < min || high > max || low > high {
+			return nil, fmt.Errorf("Value %s out of range %d-%d", part, min, max)
+		}
+
+		for i := low; i <= high; i++ {
+			field[i] = true
+		}
+	}
+
+	return field, nil
+}
+
+func (f fieldSpec) isMatch(value int) bool {
+	return f == nil || (value >= 0 && value < len(f) && f[value])
+}
+
+func parseRecurrence(r *ValidityRecurrence) (recurrence, error) {
+	var result recurrence
+	var err error
+
+	fields := []struct {
+		spec     string
+		min, max int
+		field    *fieldSpec
+	}{
+		{r.Schedule.Minute, 0, 59, &result.minute},
+		{r.Schedule.Hour, 0, 23, &result.hour},
+		{r.Schedule.DayOfMonth, 1, 31, &result.dayOfMonth},
+		{r.Schedule.DayOfWeek, 1, 7, &result.dayOfWeek},
+		{r.Schedule.Month, 0, 11, &result.month},
+		{r.Schedule.Year, 2017, 2100, &result.year},
+	}
+	for _, f := range fields {
+		if *f.field, err = parseField(f.spec, f.min, f.max); err != nil {
+			return result, err
+		}
+	}
+
+	result.interval = time.Duration(r.Interval.Days)*24*time.Hour +
+		time.Duration(r.Interval.Hours)*time.Hour +
+		time.Duration(r.Interval.Minutes)*time.Minute
+
+	return result, nil
+}
+
+func parseValiditySchedule(s *ValiditySchedule) (*validitySchedule, error) {
+	location := time.Local
+	if s.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(s.TimeZone); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &validitySchedule{location: location}
+
+	var err error
+	if s.StartTime != "" {
+		if result.start, err = time.ParseInLocation(scheduleTimeLayout, s.StartTime, location); err != nil {
+			return nil, err
+		}
+	}
+	if s.EndTime != "" {
+		if result.end, err = time.ParseInLocation(scheduleTimeLayout, s.EndTime, location); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range s.Recurrences {
+		r, err := parseRecurrence(&s.Recurrences[i])
+		if err != nil {
+			return nil, err
+		}
+		result.recurrences = append(result.recurrences, r)
+	}
+
+	return result, nil
+}
+
+// isDayMatch checks the date fields of the recurrence
+func (r *recurrence) isDayMatch(t time.Time) bool {
+	return r.year.isMatch(t.Year()) && r.month.isMatch(int(t.Month())-1) &&
+		r.dayOfMonth.isMatch(t.Day()) && r.dayOfWeek.isMatch(int(t.Weekday())+1)
+}
+
+// lastStart returns the last start of the recurrence at or before t, searching back no
+// further than since
+func (r *recurrence) lastStart(t time.Time, since time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	first := true
+	for day := t; !day.Before(since.AddDate(0, 0, -1)); day, first = day.AddDate(0, 0, -1), false {
+		if !r.isDayMatch(day) {
+			continue
+		}
+
+		hour, minute := 23, 59
+		if first {
+			hour, minute = t.Hour(), t.Minute()
+		}
+		for h := hour; h >= 0; h-- {
+			if !r.hour.isMatch(h) {
+				continue
+			}
+			m := 59
+			if h == hour {
+				m = minute
+			}
+			for ; m >= 0; m-- {
+				if r.minute.isMatch(m) {
+					start := time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, day.Location())
+					return start, !start.Before(since)
+				}
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// isActive checks if t is within the recurrence: within the interval after a start or,
+// without an interval, during a minute that matches the schedule
+func (r *recurrence) isActive(t time.Time) bool {
+	if r.interval <= 0 {
+		return r.isDayMatch(t) && r.hour.isMatch(t.Hour()) && r.minute.isMatch(t.Minute())
+	}
+
+	start, ok := r.lastStart(t, t.Add(-r.interval))
+	return ok && t.Before(start.Add(r.interval))
+}
+
+func (s *validitySchedule) isValid(t time.Time) bool {
+	t = t.In(s.location)
+	if !s.start.IsZero() && t.Before(s.start) {
+		return false
+	}
+	if !s.end.IsZero() && !t.Before(s.end) {
+		return false
+	}
+	if len(s.recurrences) == 0 {
+		return true
+	}
+
+	for i := range s.recurrences {
+		if s.recurrences[i].isActive(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nextChange returns the first time after t at which the validity of the schedule may
+// change. Recurrences are checked every minute, zero means never
+func (s *validitySchedule) nextChange(t time.Time) time.Time {
+	var next time.Time
+	earliest := func(c time.Time) {
+		if c.After(t) && (next.IsZero() || c.Before(next)) {
+			next = c
+		}
+	}
+
+	earliest(s.start)
+	earliest(s.end)
+	if len(s.recurrences) > 0 && (s.end.IsZero() || t.Before(s.end)) {
+		earliest(t.Truncate(time.Minute).Add(time.Minute))
+	}
+
+	return next
+}
+
+// prepareSchedules parses the validity schedules of the policy. A policy with a schedule
+// that cannot be parsed is never valid
+func (p *Policy) prepareSchedules() {
+	p.schedules = nil
+	p.invalidSchedule = false
+
+	for i := range p.ValiditySchedules {
+		schedule, err := parseValiditySchedule(&p.ValiditySchedules[i])
+		if err != nil {
+			log.Printf("Invalid validity schedule of policy id=%d, name=%s due to error %s\n", p.Id, p.Name, err)
+			p.invalidSchedule = true
+			continue
+		}
+		p.schedules = append(p.schedules, schedule)
+	}
+}
+
+// isValid checks if the policy is valid at t, i.e. in any of its validity schedules
+func (p *Policy) isValid(t time.Time) bool {
+	if p.invalidSchedule {
+		return false
+	}
+	if len(p.schedules) == 0 {
+		return true
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	for _, schedule := range p.schedules {
+		if schedule.isValid(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NextValidityChange returns the first time after t at which a validity schedule of a
+// policy starts or ends, decisions made at t may be cached until then. It is zero if no
+// policy has a schedule that changes after t
+func (s *Service) NextValidityChange(t time.Time) time.Time {
+	var next time.Time
+	visit := func(policies []Policy) {
+		for i := range policies {
+			for _, schedule := range policies[i].schedules {
+				c := schedule.nextChange(t)
+				if !c.IsZero() && (next.IsZero() || c.Before(next)) {
+					next = c
+				}
+			}
+		}
+	}
+
+	visit(s.Policies)
+	for _, zone := range s.SecurityZones {
+		visit(zone.Policies)
+	}
+	if s.TagPolicies != nil {
+		if c := s.TagPolicies.NextValidityChange(t); !c.IsZero() && (next.IsZero() || c.Before(next)) {
+			next = c
+		}
+	}
+
+	return next
+}
@@ -0,0 +1,96 @@
+package ranger
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValiditySchedule(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+
+	workdays := ValiditySchedule{
+		StartTime: "2020/01/01 00:00:00",
+		EndTime:   "2020/12/31 00:00:00",
+		TimeZone:  "America/New_York",
+		Recurrences: []ValidityRecurrence{{
+			Schedule: ScheduleSpec{Minute: "0", Hour: "9", DayOfWeek: "2-6"},
+			Interval: ValidityInterval{Hours: 8},
+		}},
+	}
+	march := ValiditySchedule{
+		TimeZone:    "UTC",
+		Recurrences: []ValidityRecurrence{{Schedule: ScheduleSpec{Hour: "9-10", Month: "2"}}},
+	}
+	firstDays := ValiditySchedule{
+		TimeZone: "UTC",
+		Recurrences: []ValidityRecurrence{{
+			Schedule: ScheduleSpec{Minute: "0", Hour: "0", DayOfMonth: "1"},
+			Interval: ValidityInterval{Days: 3},
+		}},
+	}
+
+	cases := []struct {
+		name     string
+		schedule ValiditySchedule
+		time     time.Time
+		want     bool
+	}{
+		{"interval start", workdays, time.Date(2020, 3, 2, 9, 0, 0, 0, ny), true},
+		{"interval end", workdays, time.Date(2020, 3, 2, 16, 59, 0, 0, ny), true},
+		{"after interval", workdays, time.Date(2020, 3, 2, 17, 0, 0, 0, ny), false},
+		{"before interval", workdays, time.Date(2020, 3, 2, 8, 59, 0, 0, ny), false},
+		{"sunday", workdays, time.Date(2020, 3, 1, 10, 0, 0, 0, ny), false},
+		{"time zone", workdays, time.Date(2020, 3, 2, 14, 0, 0, 0, time.UTC), true},
+		{"after end", workdays, time.Date(2021, 3, 2, 10, 0, 0, 0, ny), false},
+		{"before start", workdays, time.Date(2019, 3, 4, 10, 0, 0, 0, ny), false},
+		{"matching minutes", march, time.Date(2022, 3, 5, 10, 30, 0, 0, time.UTC), true},
+		{"other month", march, time.Date(2022, 4, 5, 10, 30, 0, 0, time.UTC), false},
+		{"other hour", march, time.Date(2022, 3, 5, 11, 0, 0, 0, time.UTC), false},
+		{"interval across days", firstDays, time.Date(2022, 3, 3, 23, 0, 0, 0, time.UTC), true},
+		{"interval across days end", firstDays, time.Date(2022, 3, 4, 0, 0, 0, 0, time.UTC), false},
+		{"no recurrences", ValiditySchedule{EndTime: "2030/01/01 00:00:00", TimeZone: "UTC"}, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"invalid start", ValiditySchedule{StartTime: "garbage"}, time.Now(), false},
+		{"invalid time zone", ValiditySchedule{TimeZone: "Nowhere/Else"}, time.Now(), false},
+		{"invalid spec", ValiditySchedule{Recurrences: []ValidityRecurrence{{Schedule: ScheduleSpec{Hour: "25"}}}}, time.Now(), false},
+	}
+
+	for _, c := range cases {
+		p := &Policy{ValiditySchedules: []ValiditySchedule{c.schedule}}
+		p.prepareSchedules()
+		if got := p.isValid(c.time); got != c.want {
+			t.Errorf("%s: isValid(%s) = %v, want %v", c.name, c.time, got, c.want)
+		}
+	}
+}
+
+func TestNextValidityChange(t *testing.T) {
+	now := time.Date(2020, 3, 2, 14, 0, 30, 0, time.UTC)
+
+	recurring := ValiditySchedule{
+		TimeZone:    "UTC",
+		Recurrences: []ValidityRecurrence{{Schedule: ScheduleSpec{Minute: "0", Hour: "9"}, Interval: ValidityInterval{Hours: 8}}},
+	}
+
+	cases := []struct {
+		name      string
+		schedules []ValiditySchedule
+		want      time.Time
+	}{
+		{"none", nil, time.Time{}},
+		{"end", []ValiditySchedule{{EndTime: "2030/01/01 00:00:00", TimeZone: "UTC"}}, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"start", []ValiditySchedule{{StartTime: "2025/01/01 00:00:00", EndTime: "2030/01/01 00:00:00", TimeZone: "UTC"}}, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"ended", []ValiditySchedule{{EndTime: "2019/01/01 00:00:00", TimeZone: "UTC"}}, time.Time{}},
+		{"recurrence", []ValiditySchedule{recurring}, time.Date(2020, 3, 2, 14, 1, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		s := &Service{Policies: []Policy{{ValiditySchedules: c.schedules}}}
+		s.Policies[0].prepareSchedules()
+		if got := s.NextValidityChange(now); !got.Equal(c.want) {
+			t.Errorf("%s: NextValidityChange() = %s, want %s", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package ranger
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// ResourceTag is the resource of the Ranger tag service definition
+	ResourceTag = "tag"
+
+	// TagValueAttribute holds the value of a key=value tag
+	TagValueAttribute = "value"
+)
+
+// Tag is a classification of a resource (e.g. PII), it is matched against the tag
+// resource of tag based policies. The attributes are available to conditions
+type Tag struct {
+	Type       string
+	Attributes map[string]string
+}
+
+// ParseTags converts key/value tags, such as the tags of a bucket, to Ranger tags. The
+// key is the tag type and the value is stored in the value attribute. A key of the form
+// <type>.<attribute> sets an attribute of the tag type instead, for example
+// PII=true and PII.expiry_date=2020/01/01
+func ParseTags(kv map[string]string) []Tag {
+	tags := make(map[string]*Tag)
+
+	tag := func(name string) *Tag {
+		t, ok := tags[name]
+		if !ok {
+			t = &Tag{Type: name, Attributes: make(map[string]string)}
+			tags[name] = t
+		}
+		return t
+	}
+
+	for key, value := range kv {
+		if pos := strings.Index(key, "."); pos > 0 && pos < len(key)-1 {
+			tag(key[:pos]).Attributes[key[pos+1:]] = value
+			continue
+		}
+		tag(key).Attributes[TagValueAttribute] = value
+	}
+
+	var result []Tag
+	for _, t := range tags {
+		result = append(result, *t)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Type < result[j].Type })
+
+	return result
+}
+
+// tagAccessType returns the access type as it is named in the tag service definition,
+// i.e. prefixed by the name of the service definition (s3:read)
+func (s *Service) tagAccessType(accessType string) string {
+	if s.ServiceDef.Name == "" {
+		return accessType
+	}
+	return s.ServiceDef.Name + ":" + accessType
+}
+
+// evaluateTags evaluates the tag based policies for every tag of the request and records
+// the outcome in the result. A deny for any of the tags denies access. It returns if any
+// tag policy allowed or denied access
+func (s *Service) evaluateTags(r *AccessRequest, result *AccessResult) bool {
+	if s.TagPolicies == nil || len(r.Tags) == 0 {
+		return false
+	}
+
+	for _, tag := range r.Tags {
+		tagRequest := *r
+		tagRequest.Resource = AccessResource{
+			Owner:    r.Resource.Owner,
+			Elements: map[string]string{ResourceTag: tag.Type},
+		}
+		tagRequest.AccessType = s.tagAccessType(r.AccessType)
+		tagRequest.TagAttributes = tag.Attributes
+
+		tagResult := &AccessResult{PolicyId: -1, Zones: s.tagZones(result.Zones)}
+		determined := s.TagPolicies.evaluate(&tagRequest, tagResult)
+		result.IsAudited = result.IsAudited || tagResult.IsAudited
+
+		if determined && !tagResult.IsAllowed {
+			result.decide(tagResult, tag.Type)
+			return true
+		}
+		if tagResult.IsAllowed && !result.IsAllowed {
+			result.decide(tagResult, tag.Type)
+		}
+	}
+
+	return result.IsAllowed
+}
@@ -0,0 +1,82 @@
+package ranger
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+const (
+	userStoreEndpoint         = "/service/xusers/download/"
+	userStoreSecureEndpoint   = "/service/xusers/secure/download/"
+	lastKnownUserStoreVersion = "lastKnownUserStoreVersion"
+)
+
+// UserStore holds the users and groups that Ranger admin knows of, their attributes and
+// the groups of every user
+type UserStore struct {
+	UserStoreVersion    int
+	UserStoreUpdateTime int64
+	UserAttrMapping     map[string]map[string]string
+	GroupAttrMapping    map[string]map[string]string
+	UserGroupMapping    map[string][]string
+}
+
+// Groups returns the groups of the user, it implements the group provider of the
+// gateway
+func (u *UserStore) Groups(user string) ([]string, error) {
+	if u == nil {
+		return nil, nil
+	}
+	return u.UserGroupMapping[user], nil
+}
+
+// Attributes returns the attributes of the user
+func (u *UserStore) Attributes(user string) map[string]string {
+	if u == nil {
+		return nil
+	}
+	return u.UserAttrMapping[user]
+}
+
+// DownloadUserStore loads the user store if it changed since the version of current,
+// which can be nil to load it regardless. It returns ErrNotModified if Ranger reports
+// that the user store did not change
+func (c *PolicyClient) DownloadUserStore(current *UserStore) (*UserStore, error) {
+	endpoint := userStoreEndpoint
+	if c.Secure {
+		endpoint = userStoreSecureEndpoint
+	}
+
+	version := -1
+	if current != nil {
+		version = current.UserStoreVersion
+	}
+
+	params := c.params()
+	params.Add(lastKnownUserStoreVersion, strconv.Itoa(version))
+
+	data, err := c.fetch(endpoint+c.ServiceName, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var store UserStore
+	if err = json.Unmarshal(data, &store); err != nil {
+		return nil, err
+	}
+	if current != nil && store.UserStoreVersion == current.UserStoreVersion {
+		return nil, ErrNotModified
+	}
+
+	return &store, nil
+}
+
+// ReadUserStoreFile loads a user store from a json file in the format of the Ranger download
+func ReadUserStoreFile(path string) (*UserStore, error) {
+	var store UserStore
+	if err := readJSONFile(path, &store); err != nil {
+		return nil, err
+	}
+
+	return &store, nil
+}
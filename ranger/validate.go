@@ -0,0 +1,101 @@
+package ranger
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PolicyProblem is a part of a policy that is not declared by the service definition,
+// it never matches a request
+type PolicyProblem struct {
+	Service    string
+	Zone       string `json:",omitempty"`
+	PolicyId   int
+	PolicyName string
+	Problem    string
+}
+
+func (p PolicyProblem) String() string {
+	return fmt.Sprintf("policy id=%d name=%s of service=%s: %s", p.PolicyId, p.PolicyName, p.Service, p.Problem)
+}
+
+// Validate cross-checks the resources, access types and conditions of the policies, of
+// the zones and the tag service, with the service definitions. Parts of the definition
+// that are not downloaded, e.g. no access types, are not checked
+func (s *Service) Validate() []PolicyProblem {
+	resources := make(map[string]bool)
+	for _, resource := range s.ServiceDef.Resources {
+		resources[resource.Name] = true
+	}
+	accessTypes := make(map[string]bool)
+	for _, accessType := range s.ServiceDef.AccessTypes {
+		accessTypes[accessType.Name] = true
+	}
+	conditions := make(map[string]bool)
+	for _, condition := range s.ServiceDef.PolicyConditions {
+		conditions[condition.Name] = true
+	}
+
+	var problems []PolicyProblem
+	validate := func(zone string, policies []Policy) {
+		for i := range policies {
+			p := &policies[i]
+			report := func(format string, args ...interface{}) {
+				problems = append(problems, PolicyProblem{
+					Service:    s.ServiceName,
+					Zone:       zone,
+					PolicyId:   p.Id,
+					PolicyName: p.Name,
+					Problem:    fmt.Sprintf(format, args...),
+				})
+			}
+
+			names := make([]string, 0, len(p.Resources))
+			for name := range p.Resources {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if len(resources) > 0 && !resources[name] {
+					report("resource %s is not declared", name)
+				}
+			}
+
+			for _, items := range [][]PolicyItem{p.PolicyItems, p.DenyPolicyItems, p.AllowExceptions, p.DenyExceptions} {
+				for _, item := range items {
+					for _, access := range item.Accesses {
+						if len(accessTypes) > 0 && !accessTypes[access.Type] {
+							report("access type %s is not declared", access.Type)
+						}
+					}
+					for _, condition := range item.Conditions {
+						if len(conditions) > 0 && !conditions[condition.Type] {
+							report("condition %s is not declared", condition.Type)
+						}
+					}
+				}
+			}
+
+			if len(p.ValiditySchedules) > 0 && p.invalidSchedule {
+				report("validity schedule cannot be parsed")
+			}
+		}
+	}
+
+	validate("", s.Policies)
+
+	zones := make([]string, 0, len(s.SecurityZones))
+	for name := range s.SecurityZones {
+		zones = append(zones, name)
+	}
+	sort.Strings(zones)
+	for _, name := range zones {
+		validate(name, s.SecurityZones[name].Policies)
+	}
+
+	if s.TagPolicies != nil {
+		problems = append(problems, s.TagPolicies.Validate()...)
+	}
+
+	return problems
+}
@@ -0,0 +1,97 @@
+package ranger
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	def := ServiceDefinition{
+		Resources:        []ServiceResource{{Name: "path"}},
+		AccessTypes:      []AccessTypes{{Name: "read"}},
+		PolicyConditions: []PolicyCondition{{Name: ConditionIpRange}},
+	}
+	item := func(access string, conditions ...string) []PolicyItem {
+		pi := PolicyItem{Users: []string{"bob"}, Accesses: []Access{{Type: access, IsAllowed: true}}}
+		for _, condition := range conditions {
+			pi.Conditions = append(pi.Conditions, Condition{Type: condition})
+		}
+		return []PolicyItem{pi}
+	}
+	path := map[string]ResourceData{"path": {Values: []string{"/a"}}}
+
+	cases := []struct {
+		name    string
+		service *Service
+		want    []string
+	}{
+		{"valid", &Service{ServiceDef: def, Policies: []Policy{{Id: 1, Resources: path, PolicyItems: item("read", ConditionIpRange)}}}, nil},
+		{"resource", &Service{ServiceDef: def, Policies: []Policy{{Id: 1, Resources: map[string]ResourceData{"paht": {}}}}},
+			[]string{"resource paht is not declared"}},
+		{"access type", &Service{ServiceDef: def, Policies: []Policy{{Id: 1, Resources: path, DenyPolicyItems: item("raed")}}},
+			[]string{"access type raed is not declared"}},
+		{"condition", &Service{ServiceDef: def, Policies: []Policy{{Id: 1, Resources: path, AllowExceptions: item("read", "ip-rnage")}}},
+			[]string{"condition ip-rnage is not declared"}},
+		{"nothing declared", &Service{Policies: []Policy{{Id: 1, Resources: path, PolicyItems: item("write", ConditionTimeOfDay)}}}, nil},
+		{"schedule", &Service{ServiceDef: def, Policies: []Policy{{Id: 1, Resources: path, ValiditySchedules: []ValiditySchedule{{StartTime: "garbage"}}}}},
+			[]string{"validity schedule cannot be parsed"}},
+		{"zone", &Service{ServiceDef: def, SecurityZones: map[string]*SecurityZone{"z": {Policies: []Policy{{Id: 2, Resources: map[string]ResourceData{"bucket": {}}}}}}},
+			[]string{"resource bucket is not declared"}},
+		{"tag service", &Service{ServiceDef: def, TagPolicies: &Service{
+			ServiceDef: ServiceDefinition{Resources: []ServiceResource{{Name: ResourceTag}}},
+			Policies:   []Policy{{Id: 9, Resources: path}}}},
+			[]string{"resource path is not declared"}},
+	}
+
+	for _, c := range cases {
+		c.service.prepare()
+
+		var got []string
+		for _, problem := range c.service.Validate() {
+			got = append(got, problem.Problem)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%s: problems %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	invalid := `{"serviceName":"s3","policyVersion":%d,"serviceDef":{"resources":[{"name":"path"}]},"policies":[{"id":1,"resources":{"paht":{}}}]}`
+	valid := `{"serviceName":"s3","policyVersion":%d,"serviceDef":{"resources":[{"name":"path"}]},"policies":[{"id":1,"resources":{"path":{}}}]}`
+
+	cases := []struct {
+		strict   bool
+		policies string
+		err      bool
+		version  int
+		problems int
+	}{
+		{false, invalid, false, 1, 1},
+		{true, invalid, true, 0, 1},
+		{true, valid, false, 1, 0},
+	}
+
+	for _, c := range cases {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, c.policies, 1)
+		}))
+		refresher := NewPolicyRefresher(NewPolicyClient("s3", []string{srv.URL}), 0, 0)
+		refresher.Strict = c.strict
+		err := refresher.Refresh()
+		srv.Close()
+
+		version := 0
+		if service := refresher.Service(); service != nil {
+			version = service.PolicyVersion
+		}
+		if (err != nil) != c.err || version != c.version || len(refresher.Problems()) != c.problems {
+			t.Errorf("strict=%v: error %v version %d problems %v", c.strict, err, version, refresher.Problems())
+		}
+		if rejected := refresher.Rejected(); (rejected > 0) != c.err {
+			t.Errorf("strict=%v: rejected %d", c.strict, rejected)
+		}
+	}
+}
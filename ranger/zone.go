@@ -0,0 +1,83 @@
+package ranger
+
+import (
+	"sort"
+)
+
+// SecurityZone is a part of the resources of a service with its own policies, as it is
+// downloaded with the policies of the service
+type SecurityZone struct {
+	ZoneName                     string
+	Resources                    []map[string][]string
+	Policies                     []Policy
+	ContainsAssociatedTagService bool
+
+	matchers []*policyResourceMatcher // non json
+}
+
+// prepare creates the matchers of the zone resources and prepares the zone policies.
+// Everything below a zone resource is part of the zone
+func (z *SecurityZone) prepare(serviceDef *ServiceDefinition, implied map[string][]string) {
+	if z.matchers == nil {
+		for _, resource := range z.Resources {
+			resources := make(map[string]ResourceData)
+			for name, values := range resource {
+				resources[name] = ResourceData{Values: values, IsRecursive: true}
+			}
+			matcher := newPolicyResourceMatcher(serviceDef, resources)
+			matcher.children = nil
+			z.matchers = append(z.matchers, matcher)
+		}
+	}
+
+	preparePolicies(serviceDef, z.Policies, implied)
+}
+
+func (z *SecurityZone) isMatch(r *AccessRequest) bool {
+	for _, matcher := range z.matchers {
+		if matcher.isMatch(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// zonesOf returns the names of the zones that contain the resource of the request
+func (s *Service) zonesOf(r *AccessRequest) []string {
+	var zones []string
+	for name, zone := range s.SecurityZones {
+		if zone.isMatch(r) {
+			zones = append(zones, name)
+		}
+	}
+	sort.Strings(zones)
+
+	return zones
+}
+
+// policiesFor returns the policies to evaluate for a resource in the zones: the policies
+// of the zones and the policies that are not in a zone
+func (s *Service) policiesFor(zones []string) [][]Policy {
+	policies := make([][]Policy, 0, len(zones)+1)
+	for _, name := range zones {
+		if zone, ok := s.SecurityZones[name]; ok {
+			policies = append(policies, zone.Policies)
+		}
+	}
+
+	return append(policies, s.Policies)
+}
+
+// tagZones returns the zones of which the tag policies apply, i.e. the zones that are
+// associated with the tag service
+func (s *Service) tagZones(zones []string) []string {
+	var tagZones []string
+	for _, name := range zones {
+		if zone, ok := s.SecurityZones[name]; ok && zone.ContainsAssociatedTagService {
+			tagZones = append(tagZones, name)
+		}
+	}
+
+	return tagZones
+}
@@ -0,0 +1,81 @@
+package ranger
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestZones(t *testing.T) {
+	def := ServiceDefinition{
+		Name:        "s3",
+		AccessTypes: []AccessTypes{{Name: "read"}},
+		Resources: []ServiceResource{
+			{Name: "bucket", Level: 10, Matcher: DefaultResourceMatcher},
+			{Name: "path", Level: 20, Parent: "bucket", Matcher: PathResourceMatcher},
+		},
+	}
+	read := func(users ...string) []PolicyItem {
+		return []PolicyItem{{Users: users, Accesses: []Access{{Type: "read", IsAllowed: true}}}}
+	}
+	resources := func(bucket string) map[string]ResourceData {
+		return map[string]ResourceData{"bucket": {Values: []string{bucket}}, "path": {Values: []string{"*"}}}
+	}
+
+	s := &Service{
+		ServiceName: "s3",
+		ServiceDef:  def,
+		Policies:    []Policy{{Id: 1, IsEnabled: true, Resources: resources("*"), PolicyItems: read("carol")}},
+		SecurityZones: map[string]*SecurityZone{
+			"finance": {
+				ZoneName:                     "finance",
+				Resources:                    []map[string][]string{{"bucket": {"finance", "ledger*"}}},
+				Policies:                     []Policy{{Id: 5, IsEnabled: true, Resources: resources("*"), PolicyItems: read("bob")}},
+				ContainsAssociatedTagService: true,
+			},
+			"reports": {
+				ZoneName:  "reports",
+				Resources: []map[string][]string{{"bucket": {"finance"}, "path": {"reports"}}},
+				Policies:  []Policy{{Id: 6, IsEnabled: true, Resources: resources("*"), PolicyItems: read("dave")}},
+			},
+		},
+	}
+	s.prepare()
+
+	cases := []struct {
+		user    string
+		bucket  string
+		key     string
+		zones   []string
+		allowed bool
+		policy  int
+	}{
+		{"bob", "finance", "a", []string{"finance"}, true, 5},
+		{"bob", "ledger2020", "a", []string{"finance"}, true, 5},
+		{"bob", "other", "a", nil, false, -1},
+		{"carol", "other", "a", nil, true, 1},
+		{"carol", "finance", "a", []string{"finance"}, true, 1},
+		{"dave", "finance", "reports/q1", []string{"finance", "reports"}, true, 6},
+		{"dave", "finance", "a", []string{"finance"}, false, -1},
+	}
+
+	for _, c := range cases {
+		r := &AccessRequest{
+			User:       c.user,
+			AccessType: "read",
+			Resource:   AccessResource{Bucket: c.bucket, Key: c.key, Location: "/" + c.bucket + "/" + c.key},
+		}
+
+		if zones := s.zonesOf(r); fmt.Sprint(zones) != fmt.Sprint(c.zones) {
+			t.Errorf("zones of %s = %v, want %v", r.Resource.Location, zones, c.zones)
+		}
+		result := s.Evaluate(r)
+		if result.IsAllowed != c.allowed || result.PolicyId != c.policy {
+			t.Errorf("%s on %s: allowed=%v policy=%d, want %v %d", c.user, r.Resource.Location,
+				result.IsAllowed, result.PolicyId, c.allowed, c.policy)
+		}
+	}
+
+	if tagZones := s.tagZones([]string{"finance", "reports"}); fmt.Sprint(tagZones) != "[finance]" {
+		t.Errorf("tag zones %v, want [finance]", tagZones)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"s3gw/ranger"
+)
+
+// rgwRequestIdHeader is the request id RGW returns with every response
+const rgwRequestIdHeader = "X-Amz-Request-Id"
+
+type recordKey struct{}
+
+// requestRecord is the outcome of a request together with its authorization decision,
+// it is completed by the proxy and the transport and logged once the request is done
+type requestRecord struct {
+	BytesIn         int64 // updated atomically by the transport, first to be 64-bit aligned
+	BytesOut        int64
+	Start           time.Time
+	Status          int // status returned to the client
+	UpstreamStatus  int // status returned by RGW, 0 if the request was not forwarded
+	UpstreamLatency time.Duration
+	UpstreamError   error
+	RequestId       string
+}
+
+func withRecord(ctx context.Context, record *requestRecord) context.Context {
+	return context.WithValue(ctx, recordKey{}, record)
+}
+
+func recordFromContext(ctx context.Context) *requestRecord {
+	record, _ := ctx.Value(recordKey{}).(*requestRecord)
+	return record
+}
+
+// Latency returns the time since the request was received
+func (r *requestRecord) Latency() time.Duration {
+	return time.Since(r.Start)
+}
+
+// log writes the completed-request record
+func (r *requestRecord) log(req *ranger.AccessRequest, result *ranger.AccessResult) {
+	if r.UpstreamError != nil {
+		log.Printf("Upstream request failed for location=%s, user=%s due to error %s\n",
+			req.Resource.Location, req.User, r.UpstreamError)
+	}
+	log.Printf("Completed user=%s, location=%s, method=%s, accessType=%s, allowed=%t, policy=%d, status=%d, upstreamStatus=%d, bytesIn=%d, bytesOut=%d, latency=%s, upstreamLatency=%s, requestId=%s\n",
+		req.User, req.Resource.Location, req.Action, req.AccessType, result.IsAllowed, result.PolicyId,
+		r.Status, r.UpstreamStatus, atomic.LoadInt64(&r.BytesIn), r.BytesOut, r.Latency(), r.UpstreamLatency, r.RequestId)
+}
+
+// countingReader counts the bytes of the request body sent upstream, the transport
+// may still read the body after the handler returned
+type countingReader struct {
+	io.ReadCloser
+	count *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(c.count, int64(n))
+	return n, err
+}
+
+// responseRecorder records the status and the bytes of the response to the client
+type responseRecorder struct {
+	http.ResponseWriter
+	record *requestRecord
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.record.Status == 0 {
+		w.record.Status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.record.Status == 0 {
+		w.record.Status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.record.BytesOut += int64(n)
+	return n, err
+}
+
+func (w *responseRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCountingReader(t *testing.T) {
+	record := &requestRecord{}
+	body := &countingReader{ReadCloser: io.NopCloser(strings.NewReader("hello world")), count: &record.BytesIn}
+
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		t.Fatal(err)
+	}
+	if record.BytesIn != 11 {
+		t.Errorf("BytesIn = %d, want 11", record.BytesIn)
+	}
+}
+
+// deadlineWriter supports write deadlines, which responseRecorder does not implement itself
+type deadlineWriter struct {
+	*httptest.ResponseRecorder
+	deadline time.Time
+}
+
+func (w *deadlineWriter) SetWriteDeadline(deadline time.Time) error {
+	w.deadline = deadline
+	return nil
+}
+
+func TestResponseRecorder(t *testing.T) {
+	record := &requestRecord{}
+	w := &deadlineWriter{ResponseRecorder: httptest.NewRecorder()}
+	recorder := &responseRecorder{ResponseWriter: w, record: record}
+
+	recorder.Write([]byte("body"))
+	rc := http.NewResponseController(recorder)
+	if err := rc.Flush(); err != nil || !w.Flushed {
+		t.Errorf("Flush: flushed %v error %v", w.Flushed, err)
+	}
+	if err := rc.SetWriteDeadline(time.Now().Add(time.Minute)); err != nil || w.deadline.IsZero() {
+		t.Errorf("SetWriteDeadline did not reach the wrapped writer: %v", err)
+	}
+
+	if record.Status != http.StatusOK || record.BytesOut != 4 {
+		t.Errorf("status %d bytesOut %d, want 200 and 4", record.Status, record.BytesOut)
+	}
+}
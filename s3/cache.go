@@ -0,0 +1,84 @@
+package s3
+
+import (
+	"log"
+	"net/url"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// TagCache is a bounded cache of object tags keyed on bucket, key and version
+type TagCache struct {
+	client *Client
+	ttl    time.Duration
+	cache  *lru.Cache
+}
+
+type tagEntry struct {
+	tags    map[string]string
+	expires time.Time
+}
+
+func NewTagCache(client *Client, size int, ttl time.Duration) (*TagCache, error) {
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TagCache{client: client, ttl: ttl, cache: cache}, nil
+}
+
+func tagCacheKey(bucket string, key string, version string) string {
+	return bucket + "/" + key + "?" + version
+}
+
+// GetObjectTags returns the tags of the object from the cache or loads them. Objects
+// without tags (e.g. because they do not exist yet) are cached without tags, other
+// errors are returned and not cached
+func (c *TagCache) GetObjectTags(bucket string, key string, version string) (map[string]string, error) {
+	cacheKey := tagCacheKey(bucket, key, version)
+
+	if item, ok := c.cache.Get(cacheKey); ok {
+		entry := item.(*tagEntry)
+		if time.Now().Before(entry.expires) {
+			return entry.tags, nil
+		}
+	}
+
+	err, tagSet := c.client.GetObjectTags(bucket, key, version)
+	if err != nil {
+		if !IsNoTags(err) {
+			return nil, err
+		}
+		log.Printf("No tags for bucket=%s key=%s version=%s\n", bucket, key, version)
+	}
+
+	tags := TagMap(tagSet)
+	c.cache.Add(cacheKey, &tagEntry{tags: tags, expires: time.Now().Add(c.ttl)})
+
+	return tags, nil
+}
+
+// Invalidate removes the tags of the latest and the given version of an object
+func (c *TagCache) Invalidate(bucket string, key string, version string) {
+	c.cache.Remove(tagCacheKey(bucket, key, ""))
+	if version != "" {
+		c.cache.Remove(tagCacheKey(bucket, key, version))
+	}
+}
+
+// ParseTagging parses the url encoded tags of the x-amz-tagging header
+func ParseTagging(header string) (map[string]string, error) {
+	values, err := url.ParseQuery(header)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+	for key := range values {
+		tags[key] = values.Get(key)
+	}
+
+	return tags, nil
+}
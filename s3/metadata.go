@@ -0,0 +1,38 @@
+package s3
+
+import (
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// GetObjectMetadata returns the user metadata (x-amz-meta-*) of an object by lower case
+// name without the prefix, an empty version selects the latest version
+func (cfg *Client) GetObjectMetadata(bucket string, key string, version string) (error, map[string]string) {
+	client, err := cfg.newClient()
+	if err != nil {
+		return err, nil
+	}
+
+	input := &s3.HeadObjectInput{Bucket: &bucket, Key: &key}
+	if version != "" {
+		input.VersionId = &version
+	}
+
+	req, output := client.HeadObjectRequest(input)
+
+	err = req.Send()
+	if err != nil {
+		log.Printf("Cannot get metadata for bucket=%s key=%s version=%s error=%s\n", bucket, key, version, err)
+		return err, nil
+	}
+
+	metadata := make(map[string]string)
+	for name, value := range output.Metadata {
+		metadata[strings.ToLower(name)] = aws.StringValue(value)
+	}
+
+	return nil, metadata
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"log"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 )
 
@@ -12,21 +13,54 @@ type Client struct {
 	EndPoint string
 	AccessKey string
 	SecretKey string
+
+	client *s3.S3 // shared by all requests once connected
 }
 
-func (cfg *Client) GetBucketTags(bucket string) (error, []*s3.Tag) {
+// Connect creates the s3 client that is used for all requests, so its session and
+// connections are reused
+func (cfg *Client) Connect() error {
+	client, err := cfg.connect()
+	if err != nil {
+		return err
+	}
+
+	cfg.client = client
+	return nil
+}
+
+func (cfg *Client) newClient() (*s3.S3, error) {
+	if cfg.client != nil {
+		return cfg.client, nil
+	}
+
+	return cfg.connect()
+}
+
+func (cfg *Client) connect() (*s3.S3, error) {
 	creds := credentials.NewStaticCredentials(cfg.AccessKey, cfg.SecretKey, "")
 
 	_, err := creds.Get()
 	if err != nil {
 		log.Printf("bad credentials: %s\n", err)
-		return err, nil
+		return nil, err
 	}
 
 	awsCfg := aws.NewConfig().WithEndpoint(cfg.EndPoint).WithRegion("us-west-1").WithCredentials(creds)
 
 	session, err := session.NewSession(awsCfg)
-	client := s3.New(session)
+	if err != nil {
+		return nil, err
+	}
+
+	return s3.New(session), nil
+}
+
+func (cfg *Client) GetBucketTags(bucket string) (error, []*s3.Tag) {
+	client, err := cfg.newClient()
+	if err != nil {
+		return err, nil
+	}
 
 	input := &s3.GetBucketTaggingInput{Bucket: &bucket}
 
@@ -40,3 +74,49 @@ func (cfg *Client) GetBucketTags(bucket string) (error, []*s3.Tag) {
 
 	return nil, output.TagSet
 }
+
+// GetObjectTags returns the tags of an object, an empty version selects the latest version
+func (cfg *Client) GetObjectTags(bucket string, key string, version string) (error, []*s3.Tag) {
+	client, err := cfg.newClient()
+	if err != nil {
+		return err, nil
+	}
+
+	input := &s3.GetObjectTaggingInput{Bucket: &bucket, Key: &key}
+	if version != "" {
+		input.VersionId = &version
+	}
+
+	req, output := client.GetObjectTaggingRequest(input)
+
+	err = req.Send()
+	if err != nil {
+		log.Printf("Cannot get tags for bucket=%s key=%s version=%s error=%s\n", bucket, key, version, err)
+		return err, nil
+	}
+
+	return nil, output.TagSet
+}
+
+// IsNoTags checks if the error of a tag request means that there are no tags, because the
+// resource has none or does not exist
+func IsNoTags(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "NoSuchTagSet", "NoSuchTagSetError", "NoSuchKey", "NoSuchBucket", "NoSuchVersion":
+			return true
+		}
+	}
+
+	return false
+}
+
+// TagMap converts a tag set to a map of key to value
+func TagMap(tags []*s3.Tag) map[string]string {
+	m := make(map[string]string)
+	for _, tag := range tags {
+		m[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	return m
+}
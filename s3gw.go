@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"net/http"
+	"strings"
 	"log"
 	"time"
+	"s3gw/audit"
+	"s3gw/groups"
 	"s3gw/ranger"
 	"s3gw/rados"
 	"os"
+	"path/filepath"
+	"reflect"
 	"github.com/BurntSushi/toml"
 	"github.com/patrickmn/go-cache"
 	"s3gw/s3"
@@ -15,13 +23,95 @@ import (
 type RangerConfig struct {
 	ServiceName string
 	EndPoint string
+	EndPoints []string // failover urls, tried after EndPoint
+	CacheFile string
+	ClusterName string
+	RefreshInterval int // seconds
+	MaxBackoff int // seconds
+	Secure bool
+	Auth string // kerberos or basic, defaults to Auth
+	Principal string
+	Krb5Conf string
+	Username string
+	Password string
+	CAFile string
+	CertFile string
+	KeyFile string
+	InsecureSkipVerify bool
+	AuditOnly bool // evaluate and audit denials, but forward all requests
+	AuditOnlyBuckets []string // buckets in audit-only mode, wildcards allowed
+	PolicyHeader bool // return the id of the denying policy in a response header
+	SyncRoles bool // download the roles of the service
+	SyncUserStore bool // download the users and groups of Ranger admin
+	Strict bool // refuse to activate policies that do not match the service definition
+	ChangeHistory int // number of policy diffs kept for the admin endpoint
+}
+
+type TagConfig struct {
+	ObjectTags bool
+	CacheSize int
+	CacheTTL int
+}
+
+type AuditConfig struct {
+	Enabled bool
+	QueueSize int
+	BatchSize int
+	File string // json lines
+	Url string // http or solr update handler
+	Username string
+	Password string
+	KafkaBrokers []string
+	KafkaTopic string
+	SpoolDir string // spools events per destination while it is unavailable
+	SpoolMaxSize int // megabytes per destination
+}
+
+type LDAPConfig struct {
+	Url string
+	BindDN string
+	BindPassword string
+	BaseDN string
+	UserFilter string
+	GroupAttribute string
+	GroupBaseDN string
+	GroupFilter string
+	GroupNameAttribute string
+	StartTLS bool
+	CAFile string
+	InsecureSkipVerify bool
+}
+
+type GroupConfig struct {
+	Providers []string // os, file, ldap, ranger or userstore, the groups of all of them are used
+	File string // yaml or toml mapping for the file provider
+	CacheTTL int // seconds
+	LDAP LDAPConfig
+}
+
+type EnrichConfig struct {
+	ObjectMetadata bool // load the user metadata of objects for conditions
+	Headers []string // request headers that are added to the context
+}
+
+type DecisionConfig struct {
+	CacheSize int // number of decisions to cache, 0 disables the cache
+	CacheTTL int // seconds
 }
 
 type Config struct {
 	Address          string
 	Port             int
+	AdminAddress     string
+	AdminPort        int
+	AdminToken       string
 	Endpoint         string
 	Ranger           RangerConfig
+	Tags             TagConfig
+	Audit            AuditConfig
+	Groups           GroupConfig
+	Enrich           EnrichConfig
+	Decisions        DecisionConfig
 	Rados            rados.RadosClient
 	KeyFile          string
 	CertFile         string
@@ -31,11 +121,21 @@ type Config struct {
 	Keytab			 string
 }
 
-var service *ranger.Service
+var policies *ranger.PolicyRefresher
 var accessKey2Username map[string]string
 var radosClient rados.RadosClient
 var ownerCache *cache.Cache
 var s3Client s3.Client
+var tagCache *s3.TagCache
+var decisionCache *DecisionCache
+var auditor *audit.Auditor
+var auditOnly bool
+var auditOnlyBuckets []string
+var policyHeader bool
+var groupProvider groups.GroupProvider
+
+// agentId identifies s3gw in the Ranger audit
+const agentId = "s3gw"
 
 func ReadConfig(path string)(Config) {
 	_, err := os.Stat(path)
@@ -56,11 +156,186 @@ func ReadConfig(path string)(Config) {
 }
 
 
+// newPolicyClient creates the client for policy downloads from the configured Ranger urls
+func newPolicyClient(config *Config) (*ranger.PolicyClient, error) {
+	var rangerUrls []string
+	if config.Ranger.EndPoint != "" {
+		rangerUrls = append(rangerUrls, config.Ranger.EndPoint)
+	}
+	rangerUrls = append(rangerUrls, config.Ranger.EndPoints...)
+
+	client := ranger.NewPolicyClient(config.Ranger.ServiceName, rangerUrls)
+	client.ClusterName = config.Ranger.ClusterName
+	if err := configurePolicyClient(client, config); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// configurePolicyClient sets up tls and authentication for policy downloads
+func configurePolicyClient(client *ranger.PolicyClient, config *Config) error {
+	rc := config.Ranger
+
+	tlsConfig, err := ranger.NewTLSConfig(rc.CAFile, rc.CertFile, rc.KeyFile, rc.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	client.HTTPClient.Transport = transport
+	client.Secure = rc.Secure
+
+	auth := rc.Auth
+	if auth == "" {
+		auth = config.Auth
+	}
+
+	switch strings.ToLower(auth) {
+	case "":
+	case "basic":
+		client.Auth = &ranger.BasicAuth{Username: rc.Username, Password: rc.Password}
+	case "kerberos":
+		kerberos, err := ranger.NewKerberosAuth(rc.Principal, config.Keytab, rc.Krb5Conf)
+		if err != nil {
+			return err
+		}
+		client.Auth = kerberos
+	default:
+		return errors.New("Unknown Ranger authentication: " + auth)
+	}
+
+	return nil
+}
+
+// newGroupProvider creates the configured group providers behind a cache, the local
+// system is used if none are configured. The ranger provider shares the connection and
+// credentials of the policy client
+func newGroupProvider(config *Config, policyClient *ranger.PolicyClient) (groups.GroupProvider, error) {
+	gc := config.Groups
+	names := gc.Providers
+	if len(names) == 0 {
+		names = []string{"os"}
+	}
+
+	var providers groups.MultiProvider
+	for _, name := range names {
+		switch strings.ToLower(name) {
+		case "os":
+			providers = append(providers, &groups.OSProvider{})
+		case "file":
+			provider, err := groups.NewStaticProvider(gc.File)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, provider)
+		case "ldap":
+			lc := gc.LDAP
+			provider := groups.NewLDAPProvider(lc.Url, lc.BaseDN)
+			provider.BindDN = lc.BindDN
+			provider.BindPassword = lc.BindPassword
+			provider.GroupBaseDN = lc.GroupBaseDN
+			provider.GroupFilter = lc.GroupFilter
+			provider.StartTLS = lc.StartTLS
+			if lc.UserFilter != "" {
+				provider.UserFilter = lc.UserFilter
+			}
+			if lc.GroupAttribute != "" {
+				provider.GroupAttribute = lc.GroupAttribute
+			}
+			if lc.GroupNameAttribute != "" {
+				provider.GroupNameAttribute = lc.GroupNameAttribute
+			}
+			tlsConfig, err := ranger.NewTLSConfig(lc.CAFile, "", "", lc.InsecureSkipVerify)
+			if err != nil {
+				return nil, err
+			}
+			provider.TLSConfig = tlsConfig
+			providers = append(providers, provider)
+		case "ranger":
+			provider := groups.NewRangerProvider(policyClient.BaseUrls)
+			provider.HTTPClient = policyClient.HTTPClient
+			provider.Auth = policyClient.Auth
+			providers = append(providers, provider)
+		case "userstore":
+			if !config.Ranger.SyncUserStore {
+				return nil, errors.New("The userstore group provider requires syncuserstore")
+			}
+			providers = append(providers, &groups.UserStoreProvider{Store: policies.UserStore})
+		default:
+			return nil, errors.New("Unknown group provider: " + name)
+		}
+	}
+
+	ttl := gc.CacheTTL
+	if ttl <= 0 {
+		ttl = 300
+	}
+
+	var provider groups.GroupProvider = providers
+	if len(providers) == 1 {
+		provider = providers[0]
+	}
+
+	return groups.NewCachedProvider(provider, time.Duration(ttl) * time.Second), nil
+}
+
+// newAuditor creates the audit pipeline with a sink for every configured destination
+func newAuditor(ac AuditConfig) (*audit.Auditor, error) {
+	var sinks []audit.Sink
+	var names []string
+
+	if ac.File != "" {
+		sink, err := audit.NewFileSink(ac.File)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+		names = append(names, "file")
+	}
+
+	if ac.Url != "" {
+		sink := audit.NewHTTPSink(ac.Url)
+		sink.Username = ac.Username
+		sink.Password = ac.Password
+		sinks = append(sinks, sink)
+		names = append(names, "http")
+	}
+
+	if len(ac.KafkaBrokers) > 0 {
+		if ac.KafkaTopic == "" {
+			return nil, errors.New("No kafka topic configured for audit")
+		}
+		sinks = append(sinks, audit.NewKafkaSink(ac.KafkaBrokers, ac.KafkaTopic))
+		names = append(names, "kafka")
+	}
+
+	if len(sinks) == 0 {
+		return nil, errors.New("No audit destination configured")
+	}
+
+	if ac.SpoolDir != "" {
+		for i, sink := range sinks {
+			spool, err := audit.NewSpool(names[i], sink, filepath.Join(ac.SpoolDir, names[i]), int64(ac.SpoolMaxSize) << 20)
+			if err != nil {
+				return nil, err
+			}
+			sinks[i] = spool
+		}
+	}
+
+	return audit.NewAuditor(ac.QueueSize, ac.BatchSize, sinks...), nil
+}
+
 func main() {
 	const (
 		defaultConfig = "/etc/s3gw/sg3w.toml"
 	)
 
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(check(os.Args[2:]))
+	}
+
 	configFile := flag.String("conf", defaultConfig, "configuration file")
 
 	flag.Parse()
@@ -72,6 +347,9 @@ func main() {
 		Port: config.Port,
 		Address: config.Address,
 		Endpoint: config.Endpoint,
+		AdminAddress: config.AdminAddress,
+		AdminPort: config.AdminPort,
+		AdminToken: config.AdminToken,
 		CertFile: config.CertFile,
 		KeyFile: config.KeyFile,
 		HTTPWriteTimeout: 60,
@@ -84,21 +362,110 @@ func main() {
 
 	ownerCache = cache.New(time.Hour, time.Hour)
 
-	var err error
-	service, err = ranger.GetPolicy(config.Ranger.ServiceName, config.Ranger.EndPoint)
+	policyClient, err := newPolicyClient(&config)
 	if err != nil {
-		log.Fatal("Cannot get initial policy", err)
+		log.Fatal("Cannot configure Ranger client", err)
 		panic(err)
 	}
+	policies = ranger.NewPolicyRefresher(policyClient,
+		time.Duration(config.Ranger.RefreshInterval) * time.Second,
+		time.Duration(config.Ranger.MaxBackoff) * time.Second)
+	policies.CacheFile = config.Ranger.CacheFile
+	policies.SyncRoles = config.Ranger.SyncRoles
+	policies.SyncUserStore = config.Ranger.SyncUserStore
+	policies.Strict = config.Ranger.Strict
+	if config.Ranger.ChangeHistory > 0 {
+		policies.History = config.Ranger.ChangeHistory
+	}
+
+	err = policies.Refresh()
+	if err != nil {
+		log.Printf("Cannot get initial policy from Ranger due to error %s\n", err)
+		if config.Ranger.CacheFile == "" {
+			if policies.Service() == nil {
+				log.Fatal("No policy cache configured, cannot start without policies")
+			}
+		} else if err = policies.LoadCache(); err != nil {
+			log.Fatal("Cannot load policy cache", err)
+			panic(err)
+		}
+	}
+	go policies.Run(nil)
+
+	auditOnly = config.Ranger.AuditOnly
+	auditOnlyBuckets = config.Ranger.AuditOnlyBuckets
+	policyHeader = config.Ranger.PolicyHeader
+	if auditOnly {
+		log.Printf("Policies of service=%s are not enforced, running in audit-only mode\n", config.Ranger.ServiceName)
+	}
 
 	radosClient = config.Rados
+	if err = radosClient.Connect(); err != nil {
+		log.Fatal("Cannot create ceph/rados admin client", err)
+		panic(err)
+	}
 	s3Client = s3.Client{
 		AccessKey: radosClient.AccessKey,
 		SecretKey: radosClient.SecretKey,
 		EndPoint: radosClient.EndPoint,
 	}
+	if err = s3Client.Connect(); err != nil {
+		log.Fatal("Cannot create s3 client", err)
+		panic(err)
+	}
+
+	if config.Tags.ObjectTags {
+		if config.Tags.CacheSize <= 0 {
+			config.Tags.CacheSize = 10000
+		}
+		if config.Tags.CacheTTL <= 0 {
+			config.Tags.CacheTTL = 60
+		}
+		tagCache, err = s3.NewTagCache(&s3Client, config.Tags.CacheSize, time.Duration(config.Tags.CacheTTL) * time.Second)
+		if err != nil {
+			log.Fatal("Cannot create object tag cache", err)
+			panic(err)
+		}
+	}
+
+	if config.Decisions.CacheSize > 0 {
+		if config.Decisions.CacheTTL <= 0 {
+			config.Decisions.CacheTTL = 60
+		}
+		// bucket tags changed outside the gateway are only noticed when a decision expires
+		if config.Decisions.CacheTTL > maxDecisionCacheTTL {
+			log.Printf("Decision cache ttl=%d is limited to %d seconds\n", config.Decisions.CacheTTL, maxDecisionCacheTTL)
+			config.Decisions.CacheTTL = maxDecisionCacheTTL
+		}
+		decisionCache, err = NewDecisionCache(config.Decisions.CacheSize, time.Duration(config.Decisions.CacheTTL) * time.Second)
+		if err != nil {
+			log.Fatal("Cannot create decision cache", err)
+			panic(err)
+		}
+		if tagCache != nil {
+			decisionCache.objectTags = tagCache.GetObjectTags
+		}
+	}
 
-	accessKey2Username, err = radosClient.SyncUserAccessKeys()
+	groupProvider, err = newGroupProvider(&config, policyClient)
+	if err != nil {
+		log.Fatal("Cannot create group provider", err)
+		panic(err)
+	}
+
+	registerEnrichers(&config)
+	checkServiceEnrichers(policies.Service())
+
+	if config.Audit.Enabled {
+		auditor, err = newAuditor(config.Audit)
+		if err != nil {
+			log.Fatal("Cannot create audit pipeline", err)
+			panic(err)
+		}
+		defer auditor.Close()
+	}
+
+	accessKey2Username, err = radosClient.SyncUserAccessKeys(context.Background())
 	if err != nil {
 		log.Fatal("Cannot get initial users from ceph/rados", err)
 		panic(err)
@@ -106,18 +473,15 @@ func main() {
 	ticker := time.NewTicker(5 * time.Second)
 	go func() {
 		for range ticker.C {
-			log.Printf("Updating Ranger Policies and Rados Access accessKey2Username\n")
-			newService, err := ranger.GetPolicy(config.Ranger.ServiceName, config.Ranger.EndPoint)
-			if err != nil {
-				log.Printf("Cannot refresh Ranger policy due to error %s", err)
-			} else {
-				service = newService
-			}
-
-			newKeys, err := radosClient.SyncUserAccessKeys()
+			log.Printf("Updating Rados Access accessKey2Username\n")
+			newKeys, err := radosClient.SyncUserAccessKeys(context.Background())
 			if err != nil {
 				log.Printf("Cannot refresh users from Ceph/Rados due to error %s", err)
 			} else {
+				// decisions were made for the users of the previous keys
+				if decisionCache != nil && !reflect.DeepEqual(newKeys, accessKey2Username) {
+					decisionCache.Purge()
+				}
 				accessKey2Username = newKeys
 			}
 		}
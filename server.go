@@ -3,12 +3,18 @@ package main
 import (
 	"strconv"
 	"net/http"
+	"log"
 )
 
+const defaultAdminAddress = "127.0.0.1"
+
 type ServerOptions struct {
 	Port int
 	Endpoint string
 	Address string
+	AdminAddress string
+	AdminPort int
+	AdminToken string
 	CertFile string
 	KeyFile string
 	HTTPReadTimeout int
@@ -19,11 +25,27 @@ func Serve(o ServerOptions) error {
 	addr := o.Address + ":" + strconv.Itoa(o.Port)
 
 	proxy := NewProxy(o.Endpoint)
-	http.HandleFunc("/", proxy.handle)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", proxy.handle)
+
+	if o.AdminPort > 0 {
+		// the admin endpoints are local unless configured otherwise
+		adminAddress := o.AdminAddress
+		if adminAddress == "" {
+			adminAddress = defaultAdminAddress
+		}
+		adminAddr := adminAddress + ":" + strconv.Itoa(o.AdminPort)
+		go func() {
+			log.Printf("Admin listening on: %s\n", adminAddr)
+			if err := http.ListenAndServe(adminAddr, NewAdminHandler(o.AdminToken)); err != nil {
+				log.Printf("Cannot start the admin server: %s\n", err)
+			}
+		}()
+	}
 
 	if o.CertFile != "" && o.KeyFile != "" {
-		return http.ListenAndServeTLS(addr, o.CertFile, o.KeyFile, nil)
+		return http.ListenAndServeTLS(addr, o.CertFile, o.KeyFile, mux)
 	}
 
-	return http.ListenAndServe(addr, nil)
+	return http.ListenAndServe(addr, mux)
 }